@@ -0,0 +1,408 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/Financial-Times/native-ingester/config"
+	"github.com/Financial-Times/native-ingester/native"
+	"github.com/Financial-Times/native-ingester/queue"
+	cli "github.com/jawher/mow.cli"
+)
+
+func validateConfigCommand(cmd *cli.Cmd) {
+	configFile := cmd.String(cli.StringOpt{
+		Name:   "config",
+		Value:  "",
+		Desc:   "Config file to validate (e.g. config.json)",
+		EnvVar: "CONFIG",
+	})
+
+	cmd.Action = func() {
+		if err := printConfigValidation(os.Stdout, *configFile); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			cli.Exit(1)
+		}
+	}
+}
+
+func explainCommand(cmd *cli.Cmd) {
+	configFile := cmd.String(cli.StringOpt{
+		Name:   "config",
+		Value:  "",
+		Desc:   "Config file (e.g. config.json)",
+		EnvVar: "CONFIG",
+	})
+	origin := cmd.String(cli.StringOpt{
+		Name: "origin",
+		Desc: "Origin-System-Id of the message",
+	})
+	contentType := cmd.String(cli.StringOpt{
+		Name: "content-type",
+		Desc: "Content-Type of the message",
+	})
+	publication := cmd.Strings(cli.StringsOpt{
+		Name:  "publication",
+		Value: []string{},
+		Desc:  "Publication UUIDs listed in the body of the message",
+	})
+	headers := cmd.Strings(cli.StringsOpt{
+		Name:  "header",
+		Value: []string{},
+		Desc:  "Other header of the message, as name:value",
+	})
+	body := cmd.String(cli.StringOpt{
+		Name: "body",
+		Desc: "JSON body of the message, for the rules with body conditions",
+	})
+
+	cmd.Action = func() {
+		msg, err := explainedMessage(*origin, *contentType, *publication, *headers, *body)
+		if err == nil {
+			err = printRoutingExplanation(os.Stdout, *configFile, msg)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			cli.Exit(1)
+		}
+	}
+}
+
+func replayCommand(cmd *cli.Cmd) {
+	file := cmd.String(cli.StringOpt{
+		Name: "file",
+		Desc: "Message archive to replay, one JSON object with headers and body per line, gzipped if its name ends with .gz, - to read it from the standard input",
+	})
+	nativeWriterAddress := cmd.String(cli.StringOpt{
+		Name:   "native-writer-address",
+		Value:  "",
+		Desc:   "Address (URL) of service that writes persistently the native content",
+		EnvVar: "NATIVE_RW_ADDRESS",
+	})
+	nativeWriterMaxAttempts := cmd.Int(cli.IntOpt{
+		Name:   "native-writer-max-attempts",
+		Value:  5,
+		Desc:   "Maximum number of attempts to write a message to the native writer",
+		EnvVar: "NATIVE_RW_MAX_ATTEMPTS",
+	})
+	nativeWriterInitialBackoff := cmd.String(cli.StringOpt{
+		Name:   "native-writer-initial-backoff",
+		Value:  "500ms",
+		Desc:   "Backoff before the first retry to the native writer, doubled on every following attempt (with jitter)",
+		EnvVar: "NATIVE_RW_INITIAL_BACKOFF",
+	})
+	nativeWriterMaxBackoff := cmd.String(cli.StringOpt{
+		Name:   "native-writer-max-backoff",
+		Value:  "8s",
+		Desc:   "Maximum backoff between retries to the native writer",
+		EnvVar: "NATIVE_RW_MAX_BACKOFF",
+	})
+	nativeWriterRetryDeadline := cmd.String(cli.StringOpt{
+		Name:   "native-writer-retry-deadline",
+		Value:  "30s",
+		Desc:   "Total time allowed for writing a message to the native writer, including retries. Requests still pending at the deadline are cancelled",
+		EnvVar: "NATIVE_RW_RETRY_DEADLINE",
+	})
+	configFile := cmd.String(cli.StringOpt{
+		Name:   "config",
+		Value:  "",
+		Desc:   "Config file (e.g. config.json)",
+		EnvVar: "CONFIG",
+	})
+	contentUUIDFields := cmd.Strings(cli.StringsOpt{
+		Name:   "content-uuid-fields",
+		Value:  []string{},
+		Desc:   "List of JMESPath expressions that point to UUIDs in native content bodies, tried in order. e.g. uuid,post.uuid,data.uuidv3,items[0].uuid",
+		EnvVar: "NATIVE_CONTENT_UUID_FIELDS",
+	})
+	contentUUIDDerivations := cmd.Strings(cli.StringsOpt{
+		Name:   "content-uuid-derivations",
+		Value:  []string{},
+		Desc:   "Rules deriving a UUID from the identifier found at one of the content UUID fields, as path=v3:namespace, path=v5:namespace (UUID or dns, url, oid, x500) or path=regex:expression. e.g. videoId=v5:url",
+		EnvVar: "NATIVE_CONTENT_UUID_DERIVATIONS",
+	})
+	deleteBodyMarker := cmd.String(cli.StringOpt{
+		Name:   "delete-body-marker",
+		Value:  "",
+		Desc:   "Body field that marks a message as a delete event, as a dotted path to a boolean or path=value",
+		EnvVar: "DELETE_BODY_MARKER",
+	})
+	start := cmd.Int(cli.IntOpt{
+		Name:  "start",
+		Value: 0,
+		Desc:  "Offset (line number, starting at 0) of the first message to replay",
+	})
+	end := cmd.Int(cli.IntOpt{
+		Name:  "end",
+		Value: 0,
+		Desc:  "Offset of the first message not to replay, 0 to replay until the end of the archive",
+	})
+	rate := cmd.Float64(cli.Float64Opt{
+		Name:  "rate",
+		Value: 0,
+		Desc:  "Maximum number of messages replayed per second, 0 for no limit",
+	})
+	origins := cmd.Strings(cli.StringsOpt{
+		Name:  "origin",
+		Value: []string{},
+		Desc:  "Only replay the messages of these Origin-System-Ids",
+	})
+	contentType := cmd.String(cli.StringOpt{
+		Name:  "content-type",
+		Value: "",
+		Desc:  "Only replay the messages whose Content-Type matches this regular expression",
+	})
+	dryRun := cmd.Bool(cli.BoolOpt{
+		Name:  "dry-run",
+		Value: false,
+		Desc:  "Route the messages and extract their UUID without writing them to the native writer",
+	})
+	logLevel := cmd.String(cli.StringOpt{
+		Name:   "logLevel",
+		Value:  "INFO",
+		Desc:   "Log level for the command",
+		EnvVar: "LOG_LEVEL",
+	})
+
+	cmd.Action = func() {
+		log := logger.NewUPPLogger("native-ingester-replay", *logLevel)
+		opts := queue.ReplayOptions{Start: *start, End: *end, Rate: *rate, OriginSystems: *origins}
+		if *contentType != "" {
+			re, err := regexp.Compile(*contentType)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "invalid content type expression: %s\n", err)
+				cli.Exit(1)
+			}
+			opts.ContentType = re
+		}
+
+		conf, err := config.ReadConfig(*configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "reading %s: %s\n", *configFile, err)
+			cli.Exit(1)
+		}
+		retryPolicy, err := newRetryPolicy(*nativeWriterMaxAttempts, *nativeWriterInitialBackoff, *nativeWriterMaxBackoff, *nativeWriterRetryDeadline)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			cli.Exit(1)
+		}
+
+		bodyParser, err := native.NewContentBodyParser(*contentUUIDFields, *contentUUIDDerivations...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			cli.Exit(1)
+		}
+		mh := queue.NewMessageHandler(native.NewWriter(*nativeWriterAddress, conf, bodyParser, retryPolicy, log), "Replay", log)
+		mh.DeleteOnBodyMarker(*deleteBodyMarker)
+		if *dryRun {
+			mh.DryRun()
+		}
+
+		in, err := openArchive(*file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			cli.Exit(1)
+		}
+		defer in.Close()
+
+		summary, err := queue.Replay(in, mh.ProcessMessage, opts, log)
+		printReplaySummary(os.Stdout, summary)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			cli.Exit(1)
+		}
+	}
+}
+
+// printConfigValidation lists every rule of the config file per origin system, followed by its warnings and errors
+func printConfigValidation(out io.Writer, configFile string) error {
+	conf, err := config.ReadConfig(configFile)
+	if conf == nil {
+		return fmt.Errorf("reading %s: %w", configFile, err)
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, origin := range conf.OriginSystems() {
+		fmt.Fprintf(tw, "%s\n", origin)
+		for i, rule := range conf.Config[origin] {
+			fmt.Fprintf(tw, "  #%d\t%s\n", i, describeRule(rule))
+		}
+	}
+	tw.Flush()
+
+	for _, warning := range conf.Warnings() {
+		fmt.Fprintf(out, "WARNING: %s\n", warning)
+	}
+	if err != nil {
+		return fmt.Errorf("%s is invalid:\n%w", configFile, err)
+	}
+	fmt.Fprintf(out, "%s is valid\n", configFile)
+	return nil
+}
+
+// explainedMessage builds the message to route from the options of the explain command
+func explainedMessage(origin string, contentType string, publication []string, headers []string, body string) (config.Message, error) {
+	msg := config.Message{OriginSystemID: origin, ContentType: contentType, Headers: make(map[string]string), Body: make(map[string]interface{})}
+	for _, header := range headers {
+		name, value, found := strings.Cut(header, ":")
+		if !found {
+			return msg, fmt.Errorf("invalid header %q: expected name:value", header)
+		}
+		msg.Headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	if body != "" {
+		if err := json.Unmarshal([]byte(body), &msg.Body); err != nil {
+			return msg, fmt.Errorf("invalid body: %w", err)
+		}
+	}
+	if len(publication) > 0 {
+		pubs := make([]interface{}, len(publication))
+		for i, p := range publication {
+			pubs[i] = p
+		}
+		msg.Body["publication"] = pubs
+	}
+	return msg, nil
+}
+
+// printRoutingExplanation shows which rule of the config file, and so which collection, a message would be routed to
+func printRoutingExplanation(out io.Writer, configFile string, msg config.Message) error {
+	conf, err := config.ReadConfig(configFile)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", configFile, err)
+	}
+
+	rule, index, err := conf.Route(msg)
+	if err != nil {
+		return errors.New("no collection: " + err.Error())
+	}
+	fmt.Fprintf(out, "Matched %s rule #%d: %s\n", msg.OriginSystemID, index, strings.ReplaceAll(describeRule(rule), "\t", "  "))
+	fmt.Fprintf(out, "Collection: %s\n", strings.Join(rule.Collections(), ", "))
+	return nil
+}
+
+func describeRule(rule config.OriginSystemConfig) string {
+	publication := "any"
+	if len(rule.Publication) > 0 {
+		publication = strings.Join(rule.Publication, ",")
+	}
+	description := fmt.Sprintf("content_type=%s\tpublication=%s\tcollection=%s", rule.ContentType, publication, rule.Collection)
+	if len(rule.AdditionalCollections) > 0 {
+		description += "\tadditional_collections=" + strings.Join(rule.AdditionalCollections, ",")
+	}
+	if len(rule.Headers) > 0 {
+		names := make([]string, 0, len(rule.Headers))
+		for name := range rule.Headers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			description += fmt.Sprintf("\theader %s=%s", name, rule.Headers[name])
+		}
+	}
+	for _, condition := range rule.Body {
+		description += "\tbody " + describeCondition(condition)
+	}
+	if rule.Priority != 0 {
+		description += fmt.Sprintf("\tpriority=%d", rule.Priority)
+	}
+	if len(rule.UUIDFields) > 0 {
+		description += "\tuuid_fields=" + strings.Join(rule.UUIDFields, ",")
+	}
+	if len(rule.Transforms) > 0 {
+		description += fmt.Sprintf("\ttransforms=%d", len(rule.Transforms))
+	}
+	if rule.ForwardTopic != "" {
+		description += "\tforward_topic=" + rule.ForwardTopic
+	}
+	if rule.SkipForward {
+		description += "\tskip_forward"
+	}
+	if rule.ForwardBody != "" {
+		description += "\tforward_body=" + rule.ForwardBody
+	}
+	if rule.Schema != "" {
+		description += "\tschema=" + rule.Schema
+		if rule.SchemaMode != "" {
+			description += " (" + rule.SchemaMode + ")"
+		}
+	}
+	return description
+}
+
+// openArchive opens a message archive, decompressing it if needed
+func openArchive(file string) (io.ReadCloser, error) {
+	if file == "-" {
+		return os.Stdin, nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(file, ".gz") {
+		return f, nil
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("reading %s: %w", file, err)
+	}
+	return gzipArchive{gz, f}, nil
+}
+
+type gzipArchive struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (a gzipArchive) Close() error {
+	a.Reader.Close()
+	return a.file.Close()
+}
+
+// printReplaySummary reports how many messages of the archive were replayed, and what happened to them
+func printReplaySummary(out io.Writer, summary queue.ReplaySummary) {
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Read\t%d\n", summary.Read)
+	fmt.Fprintf(tw, "Malformed\t%d\n", summary.Malformed)
+	fmt.Fprintf(tw, "Filtered out\t%d\n", summary.Filtered)
+	fmt.Fprintf(tw, "Replayed\t%d\n", summary.Replayed)
+	fmt.Fprintf(tw, "  Ingested\t%d\n", summary.Ingested)
+	fmt.Fprintf(tw, "  Unchanged\t%d\n", summary.Unchanged)
+	fmt.Fprintf(tw, "  Skipped\t%d\n", summary.Skipped)
+	stages := make([]string, 0, len(summary.Failed))
+	for stage := range summary.Failed {
+		stages = append(stages, stage)
+	}
+	sort.Strings(stages)
+	for _, stage := range stages {
+		fmt.Fprintf(tw, "  Failed at %s\t%d\n", stage, summary.Failed[stage])
+	}
+	fmt.Fprintf(tw, "Duration\t%v\n", summary.Duration.Round(time.Millisecond))
+	tw.Flush()
+}
+
+func describeCondition(condition config.BodyCondition) string {
+	switch {
+	case condition.Exists != nil && *condition.Exists:
+		return condition.Path + " exists"
+	case condition.Exists != nil:
+		return condition.Path + " missing"
+	case condition.Regex != "":
+		return condition.Path + "~" + condition.Regex
+	case condition.In != nil:
+		return fmt.Sprintf("%s in %v", condition.Path, condition.In)
+	default:
+		return fmt.Sprintf("%s=%v", condition.Path, condition.Equals)
+	}
+}
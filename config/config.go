@@ -3,50 +3,273 @@ package config
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"maps"
 	"os"
 	"regexp"
 	"slices"
+	"sort"
+	"strings"
+
+	"github.com/jmespath/go-jmespath"
+	"github.com/santhosh-tekuri/jsonschema/v5"
 )
 
 type OriginSystemConfig struct {
-	ContentType       string   `json:"content_type,binding:required"`
-	Publication       []string `json:"publication"`
-	Collection        string   `json:"collection,binding:required"`
+	ContentType string   `json:"content_type,binding:required"`
+	Publication []string `json:"publication"`
+	// Headers maps header names to the regular expressions their values must match
+	Headers map[string]string `json:"headers"`
+	// Body lists the conditions the body fields must meet
+	Body []BodyCondition `json:"body"`
+	// Priority orders the rules of an origin system, higher first. Rules with the same priority are tried in file order.
+	Priority   int    `json:"priority"`
+	Collection string `json:"collection,binding:required"`
+	// AdditionalCollections are written to as well as Collection
+	AdditionalCollections []string    `json:"additional_collections"`
+	UUIDFields            []string    `json:"uuid_fields"`
+	Schema                string      `json:"schema"`
+	SchemaMode            string      `json:"schema_mode"`
+	Transforms            []Transform `json:"transforms"`
+	// ForwardTopic is the topic the messages routed by the rule are forwarded to, instead of the producer topic
+	ForwardTopic string `json:"forward_topic"`
+	// SkipForward stops the messages routed by the rule from being forwarded at all
+	SkipForward bool `json:"skip_forward"`
+	// ForwardBody is the body of the forwarded messages, overriding the --forward-body option
+	ForwardBody       string `json:"forward_body"`
 	contentTypeRegexp *regexp.Regexp
+	headerRegexps     map[string]*regexp.Regexp
+	conditions        []BodyCondition
+	bodySchema        *jsonschema.Schema
+}
+
+// Collections returns every collection the messages routed by the rule are written to, its main collection first
+func (r OriginSystemConfig) Collections() []string {
+	return append([]string{r.Collection}, r.AdditionalCollections...)
+}
+
+// Transform is a change made to the body of the messages routed by a rule before they are written in the native store.
+// Exactly one of Remove, Rename, Set, CopyHeader and Truncate gives the dotted path of the body field it changes.
+type Transform struct {
+	// Remove deletes the field
+	Remove string `json:"remove,omitempty"`
+	// Rename moves the field to the path given by To
+	Rename string `json:"rename,omitempty"`
+	// Set sets the field to Value
+	Set string `json:"set,omitempty"`
+	// CopyHeader copies the value of the message header it names to the field at the path given by To
+	CopyHeader string `json:"copy_header,omitempty"`
+	// Truncate shortens a string field to MaxLength characters, or an array field to MaxLength items
+	Truncate  string      `json:"truncate,omitempty"`
+	To        string      `json:"to,omitempty"`
+	Value     interface{} `json:"value,omitempty"`
+	MaxLength int         `json:"max_length,omitempty"`
+}
+
+// validate checks that the transform has a single operation, with the parameters it needs
+func (t Transform) validate() error {
+	operations := 0
+	for _, path := range []string{t.Remove, t.Rename, t.Set, t.CopyHeader, t.Truncate} {
+		if path != "" {
+			operations++
+		}
+	}
+	switch {
+	case operations != 1:
+		return errors.New("exactly one of remove, rename, set, copy_header and truncate is required")
+	case (t.Rename != "" || t.CopyHeader != "") && t.To == "":
+		return errors.New("to is required by rename and copy_header")
+	case t.Truncate != "" && t.MaxLength <= 0:
+		return errors.New("a positive max_length is required by truncate")
+	}
+	for _, path := range []string{t.Remove, t.Rename, t.Set, t.Truncate, t.To} {
+		if path != "" && slices.Contains(strings.Split(path, "."), "") {
+			return fmt.Errorf("invalid path %q", path)
+		}
+	}
+	return nil
+}
+
+// Modes of the JSON Schema validation of the message bodies routed by a rule
+const (
+	// SchemaModeStrict rejects the messages whose body does not match the schema. It is the default mode.
+	SchemaModeStrict = "strict"
+	// SchemaModeLenient only reports the violations and writes the messages anyway
+	SchemaModeLenient = "lenient"
+)
+
+// Bodies of the forwarded messages
+const (
+	// ForwardBodyOriginal forwards the consumed body, or the content returned by the native writer for partial content.
+	// It is the default.
+	ForwardBodyOriginal = "original"
+	// ForwardBodyStored forwards the content returned by the native writer for every message type
+	ForwardBodyStored = "stored"
+	// ForwardBodyEnriched forwards the body as the ingester built it, with its lastModified and publishReference fields
+	ForwardBodyEnriched = "enriched"
+)
+
+// ValidateForwardBody checks that the forward body is one of the ForwardBody constants
+func ValidateForwardBody(body string) error {
+	if body != ForwardBodyOriginal && body != ForwardBodyStored && body != ForwardBodyEnriched {
+		return fmt.Errorf("forward body must be %q, %q or %q", ForwardBodyOriginal, ForwardBodyStored, ForwardBodyEnriched)
+	}
+	return nil
 }
 
+// BodySchema returns the compiled JSON Schema the message bodies routed by the rule must match, or nil if it has none
+func (r OriginSystemConfig) BodySchema() *jsonschema.Schema {
+	return r.bodySchema
+}
+
+// LenientSchema returns true if the messages whose body does not match the rule schema are written anyway
+func (r OriginSystemConfig) LenientSchema() bool {
+	return r.SchemaMode == SchemaModeLenient
+}
+
+// dottedUUIDField matches the plain dotted paths, always valid, that the native writer walks without JMESPath
+var dottedUUIDField = regexp.MustCompile(`^[\w\-$]+(\.[\w\-$]+)*$`)
+
 // Configuration data
 type Configuration struct {
-	Config map[string][]OriginSystemConfig
+	Config   map[string][]OriginSystemConfig
+	warnings []string
 }
 
+// Provider gives access to the Configuration currently in use
+type Provider interface {
+	Current() *Configuration
+}
+
+// Current returns the configuration itself, so that a static Configuration can be used as a Provider
+func (c *Configuration) Current() *Configuration {
+	return c
+}
+
+// validateConfig checks every rule of every origin system and compiles their content type expressions.
+// All the errors found are returned together; duplicate and unreachable rules are only recorded as warnings.
 func (c *Configuration) validateConfig() error {
-	for oKey, origCollection := range c.Config {
+	c.warnings = nil
+	var errs []error
+	schemas := jsonschema.NewCompiler()
+	for _, oKey := range c.OriginSystems() {
+		origCollection := c.Config[oKey]
 		for ocKey, val := range origCollection {
 			if val.ContentType == "" {
-				return errors.New("contentType value is mandatory")
+				errs = append(errs, fmt.Errorf("origin system %q rule %d: contentType value is mandatory", oKey, ocKey))
+			} else if re, err := regexp.Compile(val.ContentType); err != nil {
+				errs = append(errs, fmt.Errorf("origin system %q rule %d: invalid contentType expression: %w", oKey, ocKey, err))
+			} else {
+				c.Config[oKey][ocKey].contentTypeRegexp = re
 			}
 			if val.Collection == "" {
-				return errors.New("collection value is mandatory")
+				errs = append(errs, fmt.Errorf("origin system %q rule %d: collection value is mandatory", oKey, ocKey))
+			}
+			collections := val.Collections()
+			for i, collection := range collections[1:] {
+				if collection == "" || slices.Contains(collections[:i+1], collection) {
+					errs = append(errs, fmt.Errorf("origin system %q rule %d: additional collection %q is empty or listed twice", oKey, ocKey, collection))
+				}
+			}
+			for _, err := range c.Config[oKey][ocKey].compileMatcher() {
+				errs = append(errs, fmt.Errorf("origin system %q rule %d: %w", oKey, ocKey, err))
+			}
+			for _, field := range val.UUIDFields {
+				if dottedUUIDField.MatchString(field) {
+					continue
+				}
+				if _, err := jmespath.Compile(field); err != nil {
+					errs = append(errs, fmt.Errorf("origin system %q rule %d: invalid uuid_fields expression %q: %w", oKey, ocKey, field, err))
+				}
+			}
+			if val.Schema != "" {
+				if schema, err := schemas.Compile(val.Schema); err != nil {
+					errs = append(errs, fmt.Errorf("origin system %q rule %d: invalid schema %q: %w", oKey, ocKey, val.Schema, err))
+				} else {
+					c.Config[oKey][ocKey].bodySchema = schema
+				}
+			}
+			for tKey, transform := range val.Transforms {
+				if err := transform.validate(); err != nil {
+					errs = append(errs, fmt.Errorf("origin system %q rule %d: transform %d: %w", oKey, ocKey, tKey, err))
+				}
+			}
+			if val.SchemaMode != "" && val.SchemaMode != SchemaModeStrict && val.SchemaMode != SchemaModeLenient {
+				errs = append(errs, fmt.Errorf("origin system %q rule %d: schema_mode must be %q or %q", oKey, ocKey, SchemaModeStrict, SchemaModeLenient))
+			}
+			if val.SkipForward && val.ForwardTopic != "" {
+				errs = append(errs, fmt.Errorf("origin system %q rule %d: forward_topic and skip_forward cannot be set together", oKey, ocKey))
+			}
+			if val.ForwardBody != "" {
+				if err := ValidateForwardBody(val.ForwardBody); err != nil {
+					errs = append(errs, fmt.Errorf("origin system %q rule %d: %w", oKey, ocKey, err))
+				}
 			}
-			c.Config[oKey][ocKey].contentTypeRegexp = regexp.MustCompile(val.ContentType)
 		}
+		c.warnings = append(c.warnings, shadowedRules(oKey, origCollection)...)
 	}
-	return nil
+	return errors.Join(errs...)
+}
+
+// Warnings returns the problems found in the configuration that do not prevent it from being used
+func (c *Configuration) Warnings() []string {
+	return c.warnings
+}
+
+// OriginSystems returns the configured origin system IDs in alphabetical order
+func (c *Configuration) OriginSystems() []string {
+	origins := make([]string, 0, len(c.Config))
+	for origin := range c.Config {
+		origins = append(origins, origin)
+	}
+	sort.Strings(origins)
+	return origins
+}
+
+// ForwardTopics returns the forward topics named by the rules of every origin system, in alphabetical order and without duplicates
+func (c *Configuration) ForwardTopics() []string {
+	var topics []string
+	for _, rules := range c.Config {
+		for _, rule := range rules {
+			if rule.ForwardTopic != "" && !slices.Contains(topics, rule.ForwardTopic) {
+				topics = append(topics, rule.ForwardTopic)
+			}
+		}
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// HeaderNames returns the names of the headers the rules of every origin system match on, in alphabetical order and without duplicates
+func (c *Configuration) HeaderNames() []string {
+	var names []string
+	for _, rules := range c.Config {
+		for _, rule := range rules {
+			for name := range rule.Headers {
+				if !slices.Contains(names, name) {
+					names = append(names, name)
+				}
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
 }
 
-func (c *Configuration) GetCollection(originID string, contentType string, publication []interface{}) (string, error) {
-	collection := c.Config[originID]
-	if len(collection) == 0 {
-		return "", errors.New("origin system not found")
+// Route returns the first rule of the origin system of the message, by priority, whose content type expression,
+// header expressions and body conditions all match the message, with its index
+func (c *Configuration) Route(msg Message) (OriginSystemConfig, int, error) {
+	rules := c.Config[msg.OriginSystemID]
+	if len(rules) == 0 {
+		return OriginSystemConfig{}, -1, errors.New("origin system not found")
 	}
-	for _, val := range collection {
-		if val.contentTypeRegexp.MatchString(contentType) && publicationMatch(publication, val) {
-			return val.Collection, nil
+	for _, i := range evaluationOrder(rules) {
+		if rules[i].matches(msg) {
+			return rules[i], i, nil
 		}
 	}
-	return "", errors.New("origin system, content type and publication not configured")
+	return OriginSystemConfig{}, -1, errors.New("origin system, content type and publication not configured")
 }
 
 // ReadConfigFromReader reads config as a json stream from the given reader
@@ -70,15 +293,58 @@ func ReadConfig(confPath string) (c *Configuration, e error) {
 	return ReadConfigFromReader(file)
 }
 
-func publicationMatch(publication []interface{}, config OriginSystemConfig) bool {
-	if len(config.Publication) == 0 {
-		return true
+var catchAllContentTypes = []string{".*", "^.*", ".*$", "^.*$", "(.*)", "^(.*)$"}
+
+// shadowedRules reports the rules of an origin system that can never be chosen,
+// because a rule tried before them matches every message they would match
+func shadowedRules(origin string, rules []OriginSystemConfig) []string {
+	var warnings []string
+	order := evaluationOrder(rules)
+	for l, later := range order {
+		for _, earlier := range order[:l] {
+			if isDuplicateRule(rules[earlier], rules[later]) {
+				warnings = append(warnings, fmt.Sprintf("origin system %q rule %d: duplicate of rule %d", origin, later, earlier))
+				break
+			}
+			if shadows(rules[earlier], rules[later]) {
+				warnings = append(warnings, fmt.Sprintf("origin system %q rule %d: unreachable, every message it matches is matched by rule %d first", origin, later, earlier))
+				break
+			}
+		}
 	}
+	return warnings
+}
 
-	for _, pub := range publication {
-		if slices.Contains(config.Publication, pub.(string)) {
-			return true
+func isDuplicateRule(a, b OriginSystemConfig) bool {
+	return a.ContentType == b.ContentType && sameElements(a.Publication, b.Publication) &&
+		maps.Equal(a.Headers, b.Headers) && sameConditions(a.Body, b.Body)
+}
+
+// shadows only considers the content type and publication, so a rule with header or body conditions never shadows another
+func shadows(earlier, later OriginSystemConfig) bool {
+	if earlier.hasConditions() {
+		return false
+	}
+	contentTypeCovered := slices.Contains(catchAllContentTypes, earlier.ContentType) || earlier.ContentType == later.ContentType
+	publicationCovered := len(earlier.Publication) == 0 || (len(later.Publication) > 0 && containsAll(earlier.Publication, later.Publication))
+	return contentTypeCovered && publicationCovered
+}
+
+func sameConditions(a, b []BodyCondition) bool {
+	aJSON, _ := json.Marshal(a)
+	bJSON, _ := json.Marshal(b)
+	return string(aJSON) == string(bJSON)
+}
+
+func containsAll(set []string, elements []string) bool {
+	for _, e := range elements {
+		if !slices.Contains(set, e) {
+			return false
 		}
 	}
-	return false
+	return true
+}
+
+func sameElements(a, b []string) bool {
+	return containsAll(a, b) && containsAll(b, a)
 }
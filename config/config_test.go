@@ -2,6 +2,9 @@ package config
 
 import (
 	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 	"testing"
@@ -21,7 +24,7 @@ func toString(c *Configuration) string {
 		origCollection := c.Config[key]
 		str += key
 		for _, val := range origCollection {
-			str += val.ContentType + val.Collection
+			str += val.ContentType + val.Collection + strings.Join(val.UUIDFields, ",")
 		}
 	}
 	return str
@@ -57,7 +60,7 @@ func TestValidateConfig(t *testing.T) {
 					},
 				},
 			},
-			errors.New("contentType value is mandatory"),
+			errors.New(`origin system "http://cmdb.ft.com/systems/cct" rule 0: contentType value is mandatory`),
 		},
 		{
 			"Empty Collection",
@@ -70,7 +73,161 @@ func TestValidateConfig(t *testing.T) {
 					},
 				},
 			},
-			errors.New("collection value is mandatory"),
+			errors.New(`origin system "http://cmdb.ft.com/systems/cct" rule 0: collection value is mandatory`),
+		},
+		{
+			"Invalid ContentType expression",
+			&Configuration{
+				Config: map[string][]OriginSystemConfig{
+					"http://cmdb.ft.com/systems/cct": {
+						{ContentType: ".*",
+							Collection: "universal-content",
+						},
+					},
+					"http://cmdb.ft.com/systems/spark": {
+						{ContentType: "^(application/)*(vnd.ft-upp-article+json",
+							Collection: "universal-content",
+						},
+					},
+				},
+			},
+			errors.New(`origin system "http://cmdb.ft.com/systems/spark" rule 0: invalid contentType expression: error parsing regexp: missing closing ): ` + "`^(application/)*(vnd.ft-upp-article+json`"),
+		},
+		{
+			"Invalid uuid_fields expression",
+			&Configuration{
+				Config: map[string][]OriginSystemConfig{
+					"http://cmdb.ft.com/systems/cct": {
+						{ContentType: ".*",
+							Collection: "universal-content",
+							UUIDFields: []string{"post.uuid", "data.uuid-v3", "items[0].uuid", "items[0"},
+						},
+					},
+				},
+			},
+			errors.New(`origin system "http://cmdb.ft.com/systems/cct" rule 0: invalid uuid_fields expression "items[0": SyntaxError: Expected tRbracket, received: tEOF`),
+		},
+		{
+			"Invalid transforms",
+			&Configuration{
+				Config: map[string][]OriginSystemConfig{
+					"http://cmdb.ft.com/systems/cct": {
+						{ContentType: ".*",
+							Collection: "universal-content",
+							Transforms: []Transform{
+								{Remove: "internal.notes"},
+								{Remove: "a", Set: "b"},
+								{Rename: "headline"},
+								{Truncate: "body"},
+								{Set: "a..b", Value: 1},
+								{},
+							},
+						},
+					},
+				},
+			},
+			errors.New(`origin system "http://cmdb.ft.com/systems/cct" rule 0: transform 1: exactly one of remove, rename, set, copy_header and truncate is required
+origin system "http://cmdb.ft.com/systems/cct" rule 0: transform 2: to is required by rename and copy_header
+origin system "http://cmdb.ft.com/systems/cct" rule 0: transform 3: a positive max_length is required by truncate
+origin system "http://cmdb.ft.com/systems/cct" rule 0: transform 4: invalid path "a..b"
+origin system "http://cmdb.ft.com/systems/cct" rule 0: transform 5: exactly one of remove, rename, set, copy_header and truncate is required`),
+		},
+		{
+			"Forward topic of a rule skipping forward",
+			&Configuration{
+				Config: map[string][]OriginSystemConfig{
+					"http://cmdb.ft.com/systems/cct": {
+						{ContentType: ".*",
+							Collection:   "universal-content",
+							ForwardTopic: "NativeCmsPublicationEvents",
+							SkipForward:  true,
+						},
+					},
+				},
+			},
+			errors.New(`origin system "http://cmdb.ft.com/systems/cct" rule 0: forward_topic and skip_forward cannot be set together`),
+		},
+		{
+			"Invalid forward body",
+			&Configuration{
+				Config: map[string][]OriginSystemConfig{
+					"http://cmdb.ft.com/systems/cct": {
+						{ContentType: ".*",
+							Collection:  "universal-content",
+							ForwardBody: "stored",
+						},
+						{ContentType: ".*",
+							Collection:  "universal-content",
+							ForwardBody: "persisted",
+						},
+					},
+				},
+			},
+			errors.New(`origin system "http://cmdb.ft.com/systems/cct" rule 1: forward body must be "original", "stored" or "enriched"`),
+		},
+		{
+			"Invalid matcher",
+			&Configuration{
+				Config: map[string][]OriginSystemConfig{
+					"http://cmdb.ft.com/systems/cct": {
+						{ContentType: ".*",
+							Collection: "universal-content",
+							Headers:    map[string]string{"X-Schema-Version": "["},
+							Body: []BodyCondition{
+								{Path: "type", Equals: "article"},
+								{Path: "type"},
+								{Path: "type", Equals: "article", Regex: "article"},
+								{Path: "type", Regex: "("},
+								{Path: "type.", In: []interface{}{"article"}},
+							},
+						},
+					},
+				},
+			},
+			errors.New(`origin system "http://cmdb.ft.com/systems/cct" rule 0: invalid X-Schema-Version header expression: error parsing regexp: missing closing ]: ` + "`[`" + `
+origin system "http://cmdb.ft.com/systems/cct" rule 0: body condition 1: exactly one of equals, in, regex and exists is required
+origin system "http://cmdb.ft.com/systems/cct" rule 0: body condition 2: exactly one of equals, in, regex and exists is required
+origin system "http://cmdb.ft.com/systems/cct" rule 0: body condition 3: invalid regex: error parsing regexp: missing closing ): ` + "`(`" + `
+origin system "http://cmdb.ft.com/systems/cct" rule 0: body condition 4: invalid path "type."`),
+		},
+		{
+			"Invalid additional collections",
+			&Configuration{
+				Config: map[string][]OriginSystemConfig{
+					"http://cmdb.ft.com/systems/cct": {
+						{ContentType: ".*",
+							Collection:            "universal-content",
+							AdditionalCollections: []string{"audit", "", "universal-content", "audit"},
+						},
+					},
+				},
+			},
+			errors.New(`origin system "http://cmdb.ft.com/systems/cct" rule 0: additional collection "" is empty or listed twice
+origin system "http://cmdb.ft.com/systems/cct" rule 0: additional collection "universal-content" is empty or listed twice
+origin system "http://cmdb.ft.com/systems/cct" rule 0: additional collection "audit" is empty or listed twice`),
+		},
+		{
+			"All errors",
+			&Configuration{
+				Config: map[string][]OriginSystemConfig{
+					"http://cmdb.ft.com/systems/spark": {
+						{ContentType: ".*",
+							Collection: "universal-content",
+						},
+						{ContentType: "[",
+							Collection: "",
+						},
+					},
+					"http://cmdb.ft.com/systems/cct": {
+						{ContentType: "",
+							Collection: "universal-content",
+						},
+					},
+				},
+			},
+			errors.New(`origin system "http://cmdb.ft.com/systems/cct" rule 0: contentType value is mandatory` + "\n" +
+				`origin system "http://cmdb.ft.com/systems/spark" rule 1: invalid contentType expression: error parsing regexp: missing closing ]: ` + "`[`" + "\n" +
+				`origin system "http://cmdb.ft.com/systems/spark" rule 1: collection value is mandatory`),
 		},
 	}
 	for _, tt := range tests {
@@ -84,6 +241,59 @@ func TestValidateConfig(t *testing.T) {
 	}
 }
 
+func TestValidateConfigWarnings(t *testing.T) {
+	c := &Configuration{
+		Config: map[string][]OriginSystemConfig{
+			"http://cmdb.ft.com/systems/cct": {
+				{ContentType: "^(application/)*(vnd.ft-upp-page).*$",
+					Collection: "pages",
+				},
+				{ContentType: ".*",
+					Collection: "universal-content",
+				},
+				{ContentType: "^(application/)*(vnd.ft-upp-list\\+json).*$",
+					Collection: "lists",
+				},
+				{ContentType: "^(application/)*(vnd.ft-upp-page).*$",
+					Collection: "pages",
+				},
+			},
+			"http://cmdb.ft.com/systems/spark": {
+				{ContentType: ".*",
+					Publication: []string{"8e6c705e-1132-42a2-8db0-c295e29e8658", "19d50190-8656-4e91-8d34-82e646ada9c9"},
+					Collection:  "external-metadata",
+				},
+				{ContentType: ".*",
+					Publication: []string{"19d50190-8656-4e91-8d34-82e646ada9c9"},
+					Collection:  "fta-metadata",
+				},
+				{ContentType: ".*",
+					Collection: "universal-content",
+				},
+				{ContentType: ".*",
+					Publication: []string{"19d50190-8656-4e91-8d34-82e646ada9c9", "8e6c705e-1132-42a2-8db0-c295e29e8658"},
+					Collection:  "external-metadata",
+				},
+			},
+		},
+	}
+
+	err := c.validateConfig()
+	if err != nil {
+		t.Fatalf("Configuration.validateConfig() error = %v", err)
+	}
+
+	want := []string{
+		`origin system "http://cmdb.ft.com/systems/cct" rule 2: unreachable, every message it matches is matched by rule 1 first`,
+		`origin system "http://cmdb.ft.com/systems/cct" rule 3: duplicate of rule 0`,
+		`origin system "http://cmdb.ft.com/systems/spark" rule 1: unreachable, every message it matches is matched by rule 0 first`,
+		`origin system "http://cmdb.ft.com/systems/spark" rule 3: duplicate of rule 0`,
+	}
+	if strings.Join(c.Warnings(), "\n") != strings.Join(want, "\n") {
+		t.Errorf("Configuration.Warnings() = %v, want %v", c.Warnings(), want)
+	}
+}
+
 func TestReadConfig(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -107,7 +317,8 @@ func TestReadConfig(t *testing.T) {
 						},
 						{
 							"content_type": "^(application/)*(vnd.ft-upp-audio\\+json).*$",
-							"collection": "audio"
+							"collection": "audio",
+							"uuid_fields": ["id"]
 						}
 					]	
 			}`,
@@ -124,6 +335,7 @@ func TestReadConfig(t *testing.T) {
 						},
 						{ContentType: "^(application/)*(vnd.ft-upp-audio\\+json).*$",
 							Collection: "audio",
+							UUIDFields: []string{"id"},
 						},
 					},
 				},
@@ -144,7 +356,22 @@ func TestReadConfig(t *testing.T) {
 	}
 }
 
-func TestConfiguration_GetCollection(t *testing.T) {
+// publicationMessage returns a message with the given content type and publication, and no other header or body field
+func publicationMessage(originID string, contentType string, publication []interface{}) Message {
+	return Message{
+		OriginSystemID: originID,
+		ContentType:    contentType,
+		Body:           map[string]interface{}{publicationBodyField: publication},
+	}
+}
+
+// routeCollection returns the collection of the rule routing a message with the given content type and publication
+func routeCollection(c *Configuration, originID string, contentType string, publication []interface{}) (string, error) {
+	rule, _, err := c.Route(publicationMessage(originID, contentType, publication))
+	return rule.Collection, err
+}
+
+func TestConfiguration_RouteByContentType(t *testing.T) {
 	type args struct {
 		originID    string
 		contentType string
@@ -212,7 +439,7 @@ func TestConfiguration_GetCollection(t *testing.T) {
 	}
 	err := c.validateConfig()
 	if err != nil {
-		t.Errorf("Configuration.GetCollection() error = %v", err)
+		t.Errorf("Configuration.Route() error = %v", err)
 		return
 	}
 	tests := []struct {
@@ -459,19 +686,19 @@ func TestConfiguration_GetCollection(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 
-			got, err := c.GetCollection(tt.args.originID, tt.args.contentType, nil)
+			got, err := routeCollection(c, tt.args.originID, tt.args.contentType, nil)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("Configuration.GetCollection() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("Configuration.Route() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 			if got != tt.want {
-				t.Errorf("Configuration.GetCollection() = %v, want %v", got, tt.want)
+				t.Errorf("Configuration.Route() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
-func TestConfigurationMetadata_GetCollection(t *testing.T) {
+func TestConfigurationMetadata_RouteByPublication(t *testing.T) {
 	type args struct {
 		originID    string
 		contentType string
@@ -505,7 +732,7 @@ func TestConfigurationMetadata_GetCollection(t *testing.T) {
 	}
 	err := c.validateConfig()
 	if err != nil {
-		t.Errorf("Configuration.GetCollection() error = %v", err)
+		t.Errorf("Configuration.Route() error = %v", err)
 		return
 	}
 	tests := []struct {
@@ -638,14 +865,227 @@ func TestConfigurationMetadata_GetCollection(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 
-			got, err := c.GetCollection(tt.args.originID, tt.args.contentType, tt.args.publication)
+			got, err := routeCollection(c, tt.args.originID, tt.args.contentType, tt.args.publication)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("Configuration.GetCollection() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("Configuration.Route() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 			if got != tt.want {
-				t.Errorf("Configuration.GetCollection() = %v, want %v", got, tt.want)
+				t.Errorf("Configuration.Route() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestConfiguration_RouteIndex(t *testing.T) {
+	c := &Configuration{
+		Config: map[string][]OriginSystemConfig{
+			"http://cmdb.ft.com/systems/cct": {
+				{ContentType: ".*",
+					Publication: []string{"8e6c705e-1132-42a2-8db0-c295e29e8658"},
+					Collection:  "external-metadata",
+				},
+				{ContentType: ".*",
+					Collection: "universal-content",
+				},
+			},
+		},
+	}
+	if err := c.validateConfig(); err != nil {
+		t.Fatalf("Configuration.validateConfig() error = %v", err)
+	}
+
+	rule, index, err := c.Route(publicationMessage("http://cmdb.ft.com/systems/cct", "application/json", []interface{}{"8e6c705e-1132-42a2-8db0-c295e29e8658"}))
+	if err != nil || index != 0 || rule.Collection != "external-metadata" {
+		t.Errorf("Configuration.Route() = %v, %v, %v, want rule 0", rule, index, err)
+	}
+
+	rule, index, err = c.Route(publicationMessage("http://cmdb.ft.com/systems/cct", "application/json", nil))
+	if err != nil || index != 1 || rule.Collection != "universal-content" {
+		t.Errorf("Configuration.Route() = %v, %v, %v, want rule 1", rule, index, err)
+	}
+
+	_, index, err = c.Route(publicationMessage("http://cmdb.ft.com/systems/spark", "application/json", nil))
+	if err == nil || index != -1 {
+		t.Errorf("Configuration.Route() = %v, %v, want an error", index, err)
+	}
+}
+
+func TestReadConfigSchema(t *testing.T) {
+	schema := filepath.Join(t.TempDir(), "article.schema.json")
+	if err := os.WriteFile(schema, []byte(`{"type": "object", "required": ["uuid"]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := ReadConfigFromReader(strings.NewReader(fmt.Sprintf(`{
+		"http://cmdb.ft.com/systems/cct": [
+			{"content_type": "article", "collection": "universal-content", "schema": %q},
+			{"content_type": "list", "collection": "universal-content", "schema": %q, "schema_mode": "lenient"},
+			{"content_type": ".*", "collection": "universal-content"}
+		]
+	}`, schema, schema)))
+	if err != nil {
+		t.Fatalf("ReadConfigFromReader() error = %v", err)
+	}
+
+	rules := c.Config["http://cmdb.ft.com/systems/cct"]
+	if rules[0].BodySchema() == nil || rules[0].LenientSchema() {
+		t.Errorf("rule 0 should have a strict schema")
+	}
+	if rules[1].BodySchema() == nil || !rules[1].LenientSchema() {
+		t.Errorf("rule 1 should have a lenient schema")
+	}
+	if rules[2].BodySchema() != nil {
+		t.Errorf("rule 2 should not have a schema")
+	}
+}
+
+func TestReadConfigInvalidSchema(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.schema.json")
+	if err := os.WriteFile(invalid, []byte(`{"type": 42}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := ReadConfigFromReader(strings.NewReader(fmt.Sprintf(`{
+		"http://cmdb.ft.com/systems/cct": [
+			{"content_type": "article", "collection": "universal-content", "schema": %q},
+			{"content_type": "list", "collection": "universal-content", "schema": %q},
+			{"content_type": ".*", "collection": "universal-content", "schema_mode": "loose"}
+		]
+	}`, invalid, filepath.Join(dir, "missing.schema.json"))))
+
+	if err == nil {
+		t.Fatal("ReadConfigFromReader() should fail")
+	}
+	for _, want := range []string{
+		`origin system "http://cmdb.ft.com/systems/cct" rule 0: invalid schema`,
+		`origin system "http://cmdb.ft.com/systems/cct" rule 1: invalid schema`,
+		`origin system "http://cmdb.ft.com/systems/cct" rule 2: schema_mode must be "strict" or "lenient"`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("ReadConfigFromReader() error = %v, want it to contain %s", err, want)
+		}
+	}
+}
+
+func TestConfiguration_Route(t *testing.T) {
+	exists := true
+	c, err := ReadConfigFromReader(strings.NewReader(`{
+		"http://cmdb.ft.com/systems/cct": [
+			{"content_type": ".*", "collection": "universal-content"},
+			{"content_type": ".*", "collection": "pages", "priority": 10, "body": [{"path": "type", "equals": "page"}]},
+			{"content_type": ".*", "collection": "v2", "priority": 5, "headers": {"X-Schema-Version": "^2\\."}},
+			{"content_type": ".*", "collection": "lists", "priority": 5, "body": [{"path": "list.kind", "in": ["curated", "automated"]}, {"path": "list.items", "exists": true}]},
+			{"content_type": ".*", "collection": "tagged", "priority": 1, "body": [{"path": "tags", "regex": "^sport-"}]},
+			{"content_type": ".*", "collection": "external-metadata", "priority": 1, "publication": ["8e6c705e-1132-42a2-8db0-c295e29e8658"]}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("ReadConfigFromReader() error = %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		headers        map[string]string
+		body           map[string]interface{}
+		wantCollection string
+	}{
+		{"no condition met", nil, map[string]interface{}{"type": "article"}, "universal-content"},
+		{"body equals", nil, map[string]interface{}{"type": "page"}, "pages"},
+		{"priority over file order", map[string]string{"X-Schema-Version": "2.1"}, map[string]interface{}{"type": "page"}, "pages"},
+		{"header regex", map[string]string{"X-Schema-Version": "2.1"}, nil, "v2"},
+		{"header regex not matched", map[string]string{"X-Schema-Version": "1.2"}, nil, "universal-content"},
+		{"body in and exists", nil, map[string]interface{}{"list": map[string]interface{}{"kind": "curated", "items": []interface{}{}}}, "lists"},
+		{"all body conditions required", nil, map[string]interface{}{"list": map[string]interface{}{"kind": "curated"}}, "universal-content"},
+		{"regex on array item", nil, map[string]interface{}{"tags": []interface{}{"news", "sport-football"}}, "tagged"},
+		{"publication", nil, map[string]interface{}{"publication": []interface{}{"8e6c705e-1132-42a2-8db0-c295e29e8658"}}, "external-metadata"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, _, err := c.Route(Message{OriginSystemID: "http://cmdb.ft.com/systems/cct", ContentType: "application/json", Headers: tt.headers, Body: tt.body})
+			if err != nil || rule.Collection != tt.wantCollection {
+				t.Errorf("Configuration.Route() = %v, %v, want collection %v", rule.Collection, err, tt.wantCollection)
+			}
+		})
+	}
+
+	c.Config["http://cmdb.ft.com/systems/cct"][0].Body = []BodyCondition{{Path: "type", Exists: &exists}}
+	if err := c.validateConfig(); err != nil {
+		t.Fatalf("Configuration.validateConfig() error = %v", err)
+	}
+	if _, _, err := c.Route(Message{OriginSystemID: "http://cmdb.ft.com/systems/cct", ContentType: "application/json"}); err == nil {
+		t.Errorf("Configuration.Route() should not route a message matching no rule")
+	}
+}
+
+func TestValidateConfigWarningsWithPriority(t *testing.T) {
+	c := &Configuration{
+		Config: map[string][]OriginSystemConfig{
+			"http://cmdb.ft.com/systems/cct": {
+				{ContentType: "^(application/)*(vnd.ft-upp-page).*$",
+					Collection: "pages",
+				},
+				{ContentType: ".*",
+					Collection: "universal-content",
+					Priority:   1,
+				},
+				{ContentType: ".*",
+					Collection: "v2",
+					Headers:    map[string]string{"X-Schema-Version": "^2"},
+					Priority:   2,
+				},
+			},
+		},
+	}
+
+	if err := c.validateConfig(); err != nil {
+		t.Fatalf("Configuration.validateConfig() error = %v", err)
+	}
+
+	want := []string{
+		`origin system "http://cmdb.ft.com/systems/cct" rule 0: unreachable, every message it matches is matched by rule 1 first`,
+	}
+	if strings.Join(c.Warnings(), "\n") != strings.Join(want, "\n") {
+		t.Errorf("Configuration.Warnings() = %v, want %v", c.Warnings(), want)
+	}
+}
+
+func TestConfiguration_ForwardTopics(t *testing.T) {
+	c := &Configuration{
+		Config: map[string][]OriginSystemConfig{
+			"http://cmdb.ft.com/systems/cct": {
+				{ContentType: ".*", Collection: "universal-content", ForwardTopic: "NativeCmsPublicationEvents"},
+				{ContentType: ".*", Collection: "pac", ForwardTopic: "NativePacEvents"},
+			},
+			"http://cmdb.ft.com/systems/spark": {
+				{ContentType: ".*", Collection: "universal-content", ForwardTopic: "NativeCmsPublicationEvents"},
+				{ContentType: ".*", Collection: "audit", SkipForward: true},
+			},
+		},
+	}
+
+	topics := c.ForwardTopics()
+	if want := []string{"NativeCmsPublicationEvents", "NativePacEvents"}; fmt.Sprint(topics) != fmt.Sprint(want) {
+		t.Errorf("Configuration.ForwardTopics() = %v, want %v", topics, want)
+	}
+}
+
+func TestConfiguration_HeaderNames(t *testing.T) {
+	c := &Configuration{
+		Config: map[string][]OriginSystemConfig{
+			"http://cmdb.ft.com/systems/cct": {
+				{ContentType: ".*", Collection: "video", Headers: map[string]string{"X-Video-Source": ".+", "X-Region": "EU"}},
+				{ContentType: ".*", Collection: "universal-content"},
+			},
+			"http://cmdb.ft.com/systems/spark": {
+				{ContentType: ".*", Collection: "universal-content", Headers: map[string]string{"X-Region": "US"}},
+			},
+		},
+	}
+
+	names := c.HeaderNames()
+	if want := []string{"X-Region", "X-Video-Source"}; fmt.Sprint(names) != fmt.Sprint(want) {
+		t.Errorf("Configuration.HeaderNames() = %v, want %v", names, want)
+	}
+}
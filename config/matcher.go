@@ -0,0 +1,171 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// publicationBodyField is the body field listing the publications a content belongs to
+const publicationBodyField = "publication"
+
+// Message is the part of a consumed message that the rules are matched against
+type Message struct {
+	OriginSystemID string
+	ContentType    string
+	Headers        map[string]string
+	Body           map[string]interface{}
+}
+
+// BodyCondition is a condition on the value of a body field, given by its dotted path.
+// Exactly one of Equals, In, Regex and Exists must be set. When the value is an array,
+// the Equals, In and Regex conditions hold if they hold for one of its items.
+type BodyCondition struct {
+	Path   string        `json:"path"`
+	Equals interface{}   `json:"equals,omitempty"`
+	In     []interface{} `json:"in,omitempty"`
+	Regex  string        `json:"regex,omitempty"`
+	Exists *bool         `json:"exists,omitempty"`
+	regexp *regexp.Regexp
+}
+
+// compile checks that the condition has a single operator and compiles its regular expression
+func (bc *BodyCondition) compile() error {
+	if bc.Path == "" || slices.Contains(strings.Split(bc.Path, "."), "") {
+		return fmt.Errorf("invalid path %q", bc.Path)
+	}
+	operators := 0
+	for _, set := range []bool{bc.Equals != nil, bc.In != nil, bc.Regex != "", bc.Exists != nil} {
+		if set {
+			operators++
+		}
+	}
+	if operators != 1 {
+		return errors.New("exactly one of equals, in, regex and exists is required")
+	}
+	if bc.Regex != "" {
+		re, err := regexp.Compile(bc.Regex)
+		if err != nil {
+			return fmt.Errorf("invalid regex: %w", err)
+		}
+		bc.regexp = re
+	}
+	return nil
+}
+
+func (bc BodyCondition) matches(body map[string]interface{}) bool {
+	value, found := lookupPath(body, bc.Path)
+	if bc.Exists != nil {
+		return found == *bc.Exists
+	}
+	if !found {
+		return false
+	}
+	if items, isArray := value.([]interface{}); isArray {
+		return slices.ContainsFunc(items, bc.matchesValue)
+	}
+	return bc.matchesValue(value)
+}
+
+func (bc BodyCondition) matchesValue(value interface{}) bool {
+	switch {
+	case bc.Equals != nil:
+		return reflect.DeepEqual(bc.Equals, value)
+	case bc.In != nil:
+		return slices.ContainsFunc(bc.In, func(candidate interface{}) bool {
+			return reflect.DeepEqual(candidate, value)
+		})
+	case bc.regexp != nil:
+		switch value.(type) {
+		case map[string]interface{}, []interface{}, nil:
+			return false
+		}
+		return bc.regexp.MatchString(fmt.Sprint(value))
+	}
+	return false
+}
+
+// lookupPath returns the value of the body field at the dotted path
+func lookupPath(body map[string]interface{}, path string) (interface{}, bool) {
+	var value interface{} = body
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// compileMatcher compiles the header expressions and body conditions of the rule.
+// The publication list is turned into a condition on the publication body field.
+func (r *OriginSystemConfig) compileMatcher() []error {
+	var errs []error
+	r.headerRegexps = make(map[string]*regexp.Regexp, len(r.Headers))
+	for name, expression := range r.Headers {
+		re, err := regexp.Compile(expression)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s header expression: %w", name, err))
+			continue
+		}
+		r.headerRegexps[name] = re
+	}
+
+	r.conditions = nil
+	for i := range r.Body {
+		if err := r.Body[i].compile(); err != nil {
+			errs = append(errs, fmt.Errorf("body condition %d: %w", i, err))
+			continue
+		}
+		r.conditions = append(r.conditions, r.Body[i])
+	}
+	if len(r.Publication) > 0 {
+		publications := make([]interface{}, len(r.Publication))
+		for i, publication := range r.Publication {
+			publications[i] = publication
+		}
+		r.conditions = append(r.conditions, BodyCondition{Path: publicationBodyField, In: publications})
+	}
+	return errs
+}
+
+// matches returns true if the message matches the content type expression, every header expression and every body condition of the rule
+func (r OriginSystemConfig) matches(msg Message) bool {
+	if !r.contentTypeRegexp.MatchString(msg.ContentType) {
+		return false
+	}
+	for name, re := range r.headerRegexps {
+		if !re.MatchString(msg.Headers[name]) {
+			return false
+		}
+	}
+	for _, condition := range r.conditions {
+		if !condition.matches(msg.Body) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasConditions returns true if the rule matches on more than the content type and publication
+func (r OriginSystemConfig) hasConditions() bool {
+	return len(r.Headers) > 0 || len(r.Body) > 0
+}
+
+// evaluationOrder returns the indexes of the rules in the order they are tried: highest priority first, then in file order
+func evaluationOrder(rules []OriginSystemConfig) []int {
+	order := make([]int, len(rules))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		return rules[b].Priority - rules[a].Priority
+	})
+	return order
+}
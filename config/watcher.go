@@ -0,0 +1,123 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+)
+
+// Watcher keeps the Configuration read from a file up to date, swapping it atomically when the file changes.
+// An invalid file never replaces the configuration in use.
+type Watcher struct {
+	path      string
+	current   atomic.Pointer[Configuration]
+	mu        sync.Mutex
+	attempted []byte
+	applied   []byte
+	reloadErr error
+	checks    []func(*Configuration) error
+	logger    *logger.UPPLogger
+}
+
+// NewWatcher reads the configuration file at the given path and returns a Watcher serving it
+func NewWatcher(path string, logger *logger.UPPLogger) (*Watcher, error) {
+	w := &Watcher{path: path, logger: logger}
+	if _, err := w.Reload(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Current returns the last valid configuration read from the file
+func (w *Watcher) Current() *Configuration {
+	return w.current.Load()
+}
+
+// Require adds a check that a reloaded configuration must pass to replace the current one,
+// for requirements the configuration file cannot be validated against on its own
+func (w *Watcher) Require(check func(*Configuration) error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.checks = append(w.checks, check)
+}
+
+// Reload reads the file again and, if its content changed and is valid, makes it the current configuration.
+// It returns whether the configuration was swapped.
+func (w *Watcher) Reload() (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	raw, err := os.ReadFile(w.path)
+	if err != nil {
+		w.reloadErr = err
+		return false, err
+	}
+	if w.applied != nil && bytes.Equal(raw, w.applied) {
+		// the file is back to the configuration in use, e.g. after a failed read
+		w.attempted = raw
+		w.reloadErr = nil
+		return false, nil
+	}
+	if w.attempted != nil && bytes.Equal(raw, w.attempted) {
+		return false, w.reloadErr
+	}
+	w.attempted = raw
+
+	c, err := ReadConfigFromReader(bytes.NewReader(raw))
+	if err != nil {
+		w.reloadErr = err
+		return false, err
+	}
+	for _, check := range w.checks {
+		if err := check(c); err != nil {
+			w.reloadErr = err
+			return false, err
+		}
+	}
+	for _, warning := range c.Warnings() {
+		w.logger.Warnf("Configuration %s: %s", w.path, warning)
+	}
+	w.current.Store(c)
+	w.applied = raw
+	w.reloadErr = nil
+	return true, nil
+}
+
+// ReloadCheck returns the error of the last reload attempt, if it failed
+func (w *Watcher) ReloadCheck() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.reloadErr
+}
+
+// Watch reloads the configuration every time a signal is received on trigger and, if interval is positive,
+// periodically. It never returns.
+func (w *Watcher) Watch(interval time.Duration, trigger <-chan os.Signal) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-tick:
+		case <-trigger:
+			w.logger.Infof("Received signal, reloading configuration from %s", w.path)
+		}
+
+		changed, err := w.Reload()
+		if err != nil {
+			w.logger.WithError(err).Errorf("Invalid configuration in %s, keeping the previous one", w.path)
+			continue
+		}
+		if changed {
+			w.logger.Infof("Reloaded configuration from %s", w.path)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	videoConfig = `{
+		"http://cmdb.ft.com/systems/next-video-editor": [
+			{
+				"content_type": "application/json",
+				"collection": "video"
+			}
+		]
+	}`
+	videoMetadataConfig = `{
+		"http://cmdb.ft.com/systems/next-video-editor": [
+			{
+				"content_type": "application/json",
+				"collection": "video-metadata"
+			}
+		]
+	}`
+	invalidConfig = `{
+		"http://cmdb.ft.com/systems/next-video-editor": [
+			{
+				"content_type": "application/json",
+				"collection": ""
+			}
+		]
+	}`
+	videoOrigin = "http://cmdb.ft.com/systems/next-video-editor"
+)
+
+func writeConfigFile(t *testing.T, path string, content string) {
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+}
+
+func TestWatcherReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	writeConfigFile(t, path, videoConfig)
+
+	w, err := NewWatcher(path, logger.NewUnstructuredLogger())
+	require.NoError(t, err)
+	collection, err := routeCollection(w.Current(), videoOrigin, "application/json", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "video", collection)
+
+	changed, err := w.Reload()
+	assert.NoError(t, err)
+	assert.False(t, changed, "An unchanged file should not swap the configuration")
+
+	writeConfigFile(t, path, invalidConfig)
+	changed, err = w.Reload()
+	assert.EqualError(t, err, `origin system "http://cmdb.ft.com/systems/next-video-editor" rule 0: collection value is mandatory`)
+	assert.False(t, changed)
+	assert.EqualError(t, w.ReloadCheck(), `origin system "http://cmdb.ft.com/systems/next-video-editor" rule 0: collection value is mandatory`, "The reload error should be reported")
+	collection, _ = routeCollection(w.Current(), videoOrigin, "application/json", nil)
+	assert.Equal(t, "video", collection, "The previous configuration should still be in use")
+
+	writeConfigFile(t, path, videoMetadataConfig)
+	changed, err = w.Reload()
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.NoError(t, w.ReloadCheck())
+	collection, _ = routeCollection(w.Current(), videoOrigin, "application/json", nil)
+	assert.Equal(t, "video-metadata", collection, "The new configuration should be in use")
+}
+
+func TestWatcherRecoversWhenFileIsRestored(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	writeConfigFile(t, path, videoConfig)
+
+	w, err := NewWatcher(path, logger.NewUnstructuredLogger())
+	require.NoError(t, err)
+
+	require.NoError(t, os.Remove(path))
+	_, err = w.Reload()
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.ErrorIs(t, w.ReloadCheck(), os.ErrNotExist, "The read error should be reported")
+
+	writeConfigFile(t, path, videoConfig)
+	changed, err := w.Reload()
+	assert.NoError(t, err)
+	assert.False(t, changed, "The restored configuration is already in use")
+	assert.NoError(t, w.ReloadCheck(), "The read error should no longer be reported")
+}
+
+func TestWatcherReloadRequirements(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	writeConfigFile(t, path, videoConfig)
+
+	w, err := NewWatcher(path, logger.NewUnstructuredLogger())
+	require.NoError(t, err)
+	w.Require(func(c *Configuration) error {
+		if collection, _ := routeCollection(c, videoOrigin, "application/json", nil); collection != "video" {
+			return fmt.Errorf("collection %q is not supported", collection)
+		}
+		return nil
+	})
+
+	writeConfigFile(t, path, videoMetadataConfig)
+	changed, err := w.Reload()
+	assert.EqualError(t, err, `collection "video-metadata" is not supported`)
+	assert.False(t, changed)
+	assert.EqualError(t, w.ReloadCheck(), `collection "video-metadata" is not supported`, "The failed requirement should be reported")
+	collection, _ := routeCollection(w.Current(), videoOrigin, "application/json", nil)
+	assert.Equal(t, "video", collection, "The previous configuration should still be in use")
+}
+
+func TestNewWatcherFailsWithInvalidConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	writeConfigFile(t, path, invalidConfig)
+
+	_, err := NewWatcher(path, logger.NewUnstructuredLogger())
+	assert.EqualError(t, err, `origin system "http://cmdb.ft.com/systems/next-video-editor" rule 0: collection value is mandatory`)
+
+	_, err = NewWatcher(filepath.Join(t.TempDir(), "missing.json"), logger.NewUnstructuredLogger())
+	assert.Error(t, err)
+}
+
+func TestWatcherReloadsOnSignal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	writeConfigFile(t, path, videoConfig)
+
+	w, err := NewWatcher(path, logger.NewUnstructuredLogger())
+	require.NoError(t, err)
+
+	trigger := make(chan os.Signal, 1)
+	go w.Watch(0, trigger)
+
+	writeConfigFile(t, path, videoMetadataConfig)
+	trigger <- syscall.SIGHUP
+
+	assert.Eventually(t, func() bool {
+		collection, _ := routeCollection(w.Current(), videoOrigin, "application/json", nil)
+		return collection == "video-metadata"
+	}, time.Second, 10*time.Millisecond, "The configuration should be reloaded on signal")
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"syscall"
+	"time"
 
 	"github.com/Financial-Times/go-logger/v2"
 	"github.com/Financial-Times/kafka-client-go/v4"
@@ -15,6 +18,7 @@ import (
 	"github.com/Financial-Times/service-status-go/httphandlers"
 	"github.com/gorilla/mux"
 	cli "github.com/jawher/mow.cli"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
@@ -57,12 +61,61 @@ func main() {
 		Desc:   "Configured kafka consumer lag tolerance.",
 		EnvVar: "KAFKA_LAG_TOLERANCE",
 	})
+	workers := app.Int(cli.IntOpt{
+		Name:   "workers",
+		Value:  1,
+		Desc:   "Number of workers handling consumed messages in parallel. Messages about the same content UUID are always handled in order. With more than one worker, offsets are committed once a message is queued for its worker, so --forward-on-failure must be dead-letter when messages are forwarded.",
+		EnvVar: "WORKERS",
+	})
+	workerBufferSize := app.Int(cli.IntOpt{
+		Name:   "worker-buffer-size",
+		Value:  16,
+		Desc:   "Number of messages each worker can queue before consumption is paused.",
+		EnvVar: "WORKER_BUFFER_SIZE",
+	})
 	producerTopic := app.String(cli.StringOpt{
 		Name:   "producer-topic",
 		Value:  "",
 		Desc:   "The topic to write the messages to.",
 		EnvVar: "PRODUCER_TOPIC",
 	})
+	forwardBody := app.String(cli.StringOpt{
+		Name:   "forward-body",
+		Value:  config.ForwardBodyOriginal,
+		Desc:   "The body of the forwarded messages: original (the consumed body, or the native writer response for partial content), stored (the native writer response) or enriched (the body with lastModified and publishReference). Config rules can override it.",
+		EnvVar: "FORWARD_BODY",
+	})
+	forwardMaxAttempts := app.Int(cli.IntOpt{
+		Name:   "forward-max-attempts",
+		Value:  5,
+		Desc:   "Maximum number of attempts to forward a message before --forward-on-failure applies",
+		EnvVar: "FORWARD_MAX_ATTEMPTS",
+	})
+	forwardInitialBackoff := app.String(cli.StringOpt{
+		Name:   "forward-initial-backoff",
+		Value:  "500ms",
+		Desc:   "Backoff before the first retry to forward a message, doubled on every following attempt (with jitter)",
+		EnvVar: "FORWARD_INITIAL_BACKOFF",
+	})
+	forwardMaxBackoff := app.String(cli.StringOpt{
+		Name:   "forward-max-backoff",
+		Value:  "30s",
+		Desc:   "Maximum backoff between retries to forward a message",
+		EnvVar: "FORWARD_MAX_BACKOFF",
+	})
+	forwardOnFailure := app.String(cli.StringOpt{
+		Name:   "forward-on-failure",
+		Value:  forwardFailureBlock,
+		Desc:   "What happens to a consumed message that cannot be forwarded after --forward-max-attempts: block (keep retrying it, which stops consumption until it is forwarded) or dead-letter (send it to the dead-letter topic)",
+		EnvVar: "FORWARD_ON_FAILURE",
+	})
+	deadLetterTopic := app.String(cli.StringOpt{
+		Name:   "dead-letter-topic",
+		Value:  "",
+		Desc:   "The topic to write the messages that could not be processed to. Dead-lettering is disabled if empty.",
+		EnvVar: "DEAD_LETTER_TOPIC",
+	})
+
 	// Native writer configuration
 	nativeWriterAddress := app.String(cli.StringOpt{
 		Name:   "native-writer-address",
@@ -70,12 +123,114 @@ func main() {
 		Desc:   "Address (URL) of service that writes persistently the native content",
 		EnvVar: "NATIVE_RW_ADDRESS",
 	})
+	nativeWriterMaxAttempts := app.Int(cli.IntOpt{
+		Name:   "native-writer-max-attempts",
+		Value:  5,
+		Desc:   "Maximum number of attempts to write a message to the native writer. Connection errors, 5xx and 429 responses are retried.",
+		EnvVar: "NATIVE_RW_MAX_ATTEMPTS",
+	})
+	nativeWriterInitialBackoff := app.String(cli.StringOpt{
+		Name:   "native-writer-initial-backoff",
+		Value:  "500ms",
+		Desc:   "Backoff before the first retry to the native writer, doubled on every following attempt (with jitter)",
+		EnvVar: "NATIVE_RW_INITIAL_BACKOFF",
+	})
+	nativeWriterMaxBackoff := app.String(cli.StringOpt{
+		Name:   "native-writer-max-backoff",
+		Value:  "8s",
+		Desc:   "Maximum backoff between retries to the native writer",
+		EnvVar: "NATIVE_RW_MAX_BACKOFF",
+	})
+	nativeWriterRetryDeadline := app.String(cli.StringOpt{
+		Name:   "native-writer-retry-deadline",
+		Value:  "30s",
+		Desc:   "Total time allowed for writing a message to the native writer, including retries. Requests still pending at the deadline are cancelled",
+		EnvVar: "NATIVE_RW_RETRY_DEADLINE",
+	})
 	contentUUIDFields := app.Strings(cli.StringsOpt{
 		Name:   "content-uuid-fields",
 		Value:  []string{},
-		Desc:   "List of JSONPaths that point to UUIDs in native content bodies. e.g. uuid,post.uuid,data.uuidv3",
+		Desc:   "List of JMESPath expressions that point to UUIDs in native content bodies, tried in order. e.g. uuid,post.uuid,data.uuidv3,items[0].uuid",
 		EnvVar: "NATIVE_CONTENT_UUID_FIELDS",
 	})
+	contentUUIDDerivations := app.Strings(cli.StringsOpt{
+		Name:   "content-uuid-derivations",
+		Value:  []string{},
+		Desc:   "Rules deriving a UUID from the identifier found at one of the content UUID fields, as path=v3:namespace, path=v5:namespace (UUID or dns, url, oid, x500) or path=regex:expression. e.g. videoId=v5:url",
+		EnvVar: "NATIVE_CONTENT_UUID_DERIVATIONS",
+	})
+	deleteBodyMarker := app.String(cli.StringOpt{
+		Name:   "delete-body-marker",
+		Value:  "",
+		Desc:   "Body field that marks a message as a delete event, as a dotted path to a boolean (e.g. deleted) or path=value (e.g. type=ContentDeletion). Messages with the cms-content-deleted Message-Type are always deletes.",
+		EnvVar: "DELETE_BODY_MARKER",
+	})
+	dryRun := app.Bool(cli.BoolOpt{
+		Name:   "dry-run",
+		Value:  false,
+		Desc:   "Routes the consumed messages and extracts their UUID, but only logs what would be done instead of writing to the native writer, forwarding or dead-lettering them",
+		EnvVar: "DRY_RUN",
+	})
+	revisionCacheSize := app.Int(cli.IntOpt{
+		Name:   "revision-cache-size",
+		Value:  0,
+		Desc:   "Number of content UUIDs whose highest X-Content-Revision written is remembered, to reject messages with a lower revision. Disabled if 0.",
+		EnvVar: "REVISION_CACHE_SIZE",
+	})
+	deadLetterStale := app.Bool(cli.BoolOpt{
+		Name:   "dead-letter-stale",
+		Value:  false,
+		Desc:   "Send the messages rejected as stale, by the revision check or by the native writer (409 response), to the dead-letter topic",
+		EnvVar: "DEAD_LETTER_STALE",
+	})
+	recordDir := app.String(cli.StringOpt{
+		Name:   "record-dir",
+		Value:  "",
+		Desc:   "Directory where every consumed message is archived, in the format read by the replay command. Recording is disabled if empty.",
+		EnvVar: "RECORD_DIR",
+	})
+	recordMaxSize := app.Int(cli.IntOpt{
+		Name:   "record-max-size",
+		Value:  100,
+		Desc:   "Size in MB (before compression) after which a new record file is started, 0 for no limit",
+		EnvVar: "RECORD_MAX_SIZE",
+	})
+	recordRotationInterval := app.String(cli.StringOpt{
+		Name:   "record-rotation-interval",
+		Value:  "1h",
+		Desc:   "Time after which a new record file is started, 0 for no limit",
+		EnvVar: "RECORD_ROTATION_INTERVAL",
+	})
+	recordCompress := app.Bool(cli.BoolOpt{
+		Name:   "record-compress",
+		Value:  false,
+		Desc:   "Gzip the record files",
+		EnvVar: "RECORD_COMPRESS",
+	})
+	recordRetention := app.String(cli.StringOpt{
+		Name:   "record-retention",
+		Value:  "168h",
+		Desc:   "How long record files are kept, 0 to keep them forever",
+		EnvVar: "RECORD_RETENTION",
+	})
+	hashCacheSize := app.Int(cli.IntOpt{
+		Name:   "hash-cache-size",
+		Value:  0,
+		Desc:   "Number of Native-Hash values remembered per collection and content UUID, to skip writing messages whose content has not changed. Disabled if 0.",
+		EnvVar: "HASH_CACHE_SIZE",
+	})
+	hashCacheFile := app.String(cli.StringOpt{
+		Name:   "hash-cache-file",
+		Value:  "",
+		Desc:   "File persisting the Native-Hash cache across restarts. The cache is only kept in memory if empty.",
+		EnvVar: "HASH_CACHE_FILE",
+	})
+	skipUnchangedForward := app.Bool(cli.BoolOpt{
+		Name:   "skip-unchanged-forward",
+		Value:  false,
+		Desc:   "Do not forward the messages that are not written because their Native-Hash has not changed",
+		EnvVar: "SKIP_UNCHANGED_FORWARD",
+	})
 	contentType := app.String(cli.StringOpt{
 		Name:   "content-type",
 		Value:  "",
@@ -94,6 +249,12 @@ func main() {
 		Desc:   "Config file (e.g. config.json)",
 		EnvVar: "CONFIG",
 	})
+	configReloadInterval := app.String(cli.StringOpt{
+		Name:   "config-reload-interval",
+		Value:  "30s",
+		Desc:   "How often the config file is checked for changes, 0 to disable. The config file is also reloaded on SIGHUP.",
+		EnvVar: "CONFIG_RELOAD_INTERVAL",
+	})
 	panicGuideUrl := app.String(cli.StringOpt{
 		Name:   "panic-guide",
 		Value:  "",
@@ -107,23 +268,114 @@ func main() {
 		EnvVar: "LOG_LEVEL",
 	})
 
+	app.Command("validate-config", "Validates a config file and lists its rules per origin system", validateConfigCommand)
+	app.Command("explain", "Shows which rule of a config file, and which collection, a message would be routed to", explainCommand)
+	app.Command("replay", "Writes the messages of an archive to the native store, as if they were consumed from the queue", replayCommand)
+
 	app.Action = func() {
 		logger := logger.NewUPPLogger(*appName, *logLevel)
-		conf, err := config.ReadConfig(*configFile)
+		conf, err := config.NewWatcher(*configFile, logger)
 		if err != nil {
 			logger.WithError(err).Fatal("Error reading the configuration")
 		}
+		reloadInterval, err := time.ParseDuration(*configReloadInterval)
+		if err != nil {
+			logger.WithError(err).Fatal("Invalid config reload interval")
+		}
 
 		if *panicGuideUrl == "" {
 			logger.Fatal("panicGuideUrl is empty")
 		}
 
-		logger.Infof("[Startup] Using UUID paths configuration: %#v", *contentUUIDFields)
-		bodyParser := native.NewContentBodyParser(*contentUUIDFields)
-		writer := native.NewWriter(*nativeWriterAddress, *conf, bodyParser, logger)
+		retryPolicy, err := newRetryPolicy(*nativeWriterMaxAttempts, *nativeWriterInitialBackoff, *nativeWriterMaxBackoff, *nativeWriterRetryDeadline)
+		if err != nil {
+			logger.WithError(err).Fatal("Invalid native writer retry configuration")
+		}
+		logger.Infof("[Startup] Using native writer retry policy: %#v", retryPolicy)
+
+		logger.Infof("[Startup] Using UUID paths configuration: %#v, derivations: %#v", *contentUUIDFields, *contentUUIDDerivations)
+		bodyParser, err := native.NewContentBodyParser(*contentUUIDFields, *contentUUIDDerivations...)
+		if err != nil {
+			logger.WithError(err).Fatal("Invalid content UUID fields")
+		}
+		writer := native.NewWriter(*nativeWriterAddress, conf, bodyParser, retryPolicy, logger)
 		logger.Infof("[Startup] Using native writer configuration: %#v", writer)
 
 		mh := queue.NewMessageHandler(writer, *contentType, logger)
+		mh.IngestedBy(*appName)
+		if err := config.ValidateForwardBody(*forwardBody); err != nil {
+			logger.WithError(err).Fatal("Invalid forward body")
+		}
+		mh.ForwardBody(*forwardBody)
+		if *deleteBodyMarker != "" {
+			logger.Infof("[Startup] Using delete body marker: %s", *deleteBodyMarker)
+			mh.DeleteOnBodyMarker(*deleteBodyMarker)
+		}
+
+		if *dryRun {
+			logger.Warn("[Startup] Running in dry-run mode, nothing will be written to the native store or forwarded")
+			mh.DryRun()
+		}
+
+		if *hashCacheSize > 0 {
+			hashStore := queue.NewLRUHashStore(*hashCacheSize)
+			if *hashCacheFile != "" {
+				fileHashStore, err := queue.NewFileHashStore(*hashCacheFile, *hashCacheSize)
+				if err != nil {
+					logger.WithError(err).Fatal("Failed to open the Native-Hash cache file")
+				}
+				defer fileHashStore.Close()
+				hashStore = fileHashStore
+			}
+			logger.Infof("[Startup] Skipping unchanged messages, remembering %d hashes (file: %q, skip forward: %v)", *hashCacheSize, *hashCacheFile, *skipUnchangedForward)
+			mh.SkipUnchanged(hashStore, *skipUnchangedForward)
+		}
+
+		if *revisionCacheSize > 0 {
+			logger.Infof("[Startup] Rejecting stale content revisions, remembering %d content UUIDs", *revisionCacheSize)
+			mh.GuardRevisions(queue.NewRevisionGuard(*revisionCacheSize))
+		}
+		if *deadLetterStale {
+			mh.DeadLetterStale()
+		}
+
+		if *recordDir != "" {
+			recorderConfig, err := newRecorderConfig(*recordDir, *recordMaxSize, *recordRotationInterval, *recordCompress, *recordRetention)
+			if err != nil {
+				logger.WithError(err).Fatal("Invalid record configuration")
+			}
+			recorder, err := queue.NewRecorder(recorderConfig, logger)
+			if err != nil {
+				logger.WithError(err).Fatal("Failed to create message recorder")
+			}
+			defer recorder.Close()
+			logger.Infof("[Startup] Recording consumed messages: %#v", recorderConfig)
+			mh.RecordTo(recorder)
+		}
+
+		forwardRetryPolicy, err := newRetryPolicy(*forwardMaxAttempts, *forwardInitialBackoff, *forwardMaxBackoff, "0")
+		if err != nil {
+			logger.WithError(err).Fatal("Invalid forward retry configuration")
+		}
+		// a reload cannot add forward topics, so a service that forwards nothing at startup never forwards
+		forwards := *producerTopic != "" || len(conf.Current().ForwardTopics()) > 0
+		switch *forwardOnFailure {
+		case forwardFailureBlock:
+			if forwards && forwardRetryPolicy.InitialBackoff <= 0 {
+				logger.Fatal("A positive --forward-initial-backoff is required to block on forward failures")
+			}
+			if forwards && *workers > 1 {
+				logger.Fatal("Blocking on forward failures requires a single worker, as offsets are committed before the workers forward the messages: set --forward-on-failure=dead-letter to use --workers")
+			}
+		case forwardFailureDeadLetter:
+			if forwards && *deadLetterTopic == "" {
+				logger.Fatal("--dead-letter-topic is required to dead-letter forward failures")
+			}
+		default:
+			logger.Fatalf("Invalid --forward-on-failure %q, must be %q or %q", *forwardOnFailure, forwardFailureBlock, forwardFailureDeadLetter)
+		}
+		logger.Infof("[Startup] Using forward retry policy: %#v, on failure: %s", forwardRetryPolicy, *forwardOnFailure)
+		mh.RetryForward(forwardRetryPolicy, *forwardOnFailure == forwardFailureBlock)
 
 		var messageProducer *kafka.Producer
 		if *producerTopic != "" {
@@ -143,6 +395,54 @@ func main() {
 			mh.ForwardTo(messageProducer)
 		}
 
+		for _, topic := range conf.Current().ForwardTopics() {
+			if topic == *producerTopic {
+				mh.ForwardTopicTo(topic, messageProducer)
+				continue
+			}
+			topicProducer, err := kafka.NewProducer(kafka.ProducerConfig{
+				ClusterArn:              kafkaClusterArn,
+				BrokersConnectionString: *kafkaAddress,
+				Topic:                   topic,
+				Options:                 kafka.DefaultProducerOptions(),
+			})
+			if err != nil {
+				logger.WithError(err).Fatalf("Failed to create Kafka producer for forward topic %s", topic)
+			}
+			defer topicProducer.Close()
+			logger.Infof("[Startup] Producer for forward topic %s: %#v", topic, topicProducer)
+			mh.ForwardTopicTo(topic, topicProducer)
+		}
+		forwardTopics := conf.Current().ForwardTopics()
+		conf.Require(func(c *config.Configuration) error {
+			for _, topic := range c.ForwardTopics() {
+				if !slices.Contains(forwardTopics, topic) {
+					return fmt.Errorf("forward topic %q has no producer, the service must be restarted to forward to it", topic)
+				}
+			}
+			return nil
+		})
+
+		reloadSignals := make(chan os.Signal, 1)
+		signal.Notify(reloadSignals, syscall.SIGHUP)
+		go conf.Watch(reloadInterval, reloadSignals)
+
+		if *deadLetterTopic != "" {
+			deadLetterConfig := kafka.ProducerConfig{
+				ClusterArn:              kafkaClusterArn,
+				BrokersConnectionString: *kafkaAddress,
+				Topic:                   *deadLetterTopic,
+				Options:                 kafka.DefaultProducerOptions(),
+			}
+			deadLetterProducer, err := kafka.NewProducer(deadLetterConfig)
+			if err != nil {
+				logger.WithError(err).Fatal("Failed to create Kafka dead-letter producer")
+			}
+			defer deadLetterProducer.Close()
+			logger.Infof("[Startup] Dead-letter producer: %#v", deadLetterProducer)
+			mh.DeadLetterTo(deadLetterProducer)
+		}
+
 		consumerConfig := kafka.ConsumerConfig{
 			ClusterArn:              kafkaClusterArn,
 			BrokersConnectionString: *kafkaAddress,
@@ -162,18 +462,26 @@ func main() {
 		logger.Infof("[Startup] Consumer: %#v", messageConsumer)
 
 		go func() {
-			err = enableHealthCheck(*port, messageConsumer, messageProducer, writer, *panicGuideUrl, logger)
+			err = enableHealthCheck(*port, messageConsumer, messageProducer, writer, conf, mh, *panicGuideUrl, logger)
 			if err != nil {
 				logger.WithError(err).Fatal("Couldn't set up HTTP listener")
 			}
 		}()
 
-		messageConsumer.Start(mh.HandleMessage)
+		if *workers > 1 {
+			logger.Infof("[Startup] Handling messages with %d workers", *workers)
+			pipeline := queue.NewPipeline(mh.HandleMessage, queue.ContentUUIDKey(writer), *workers, *workerBufferSize)
+			defer pipeline.Close()
+			messageConsumer.Start(pipeline.Submit)
+		} else {
+			messageConsumer.Start(mh.HandleMessage)
+		}
 		defer messageConsumer.Close()
 
-		ch := make(chan os.Signal)
+		ch := make(chan os.Signal, 1)
 		signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
 		<-ch
+		mh.Stop()
 	}
 
 	err := app.Run(os.Args)
@@ -182,14 +490,50 @@ func main() {
 	}
 }
 
-func enableHealthCheck(port string, consumer *kafka.Consumer, producer *kafka.Producer, writer native.Writer, panicGuide string, logger *logger.UPPLogger) error {
-	hc := resources.NewHealthCheck(consumer, producer, writer, panicGuide, logger)
+// What happens to a consumed message that cannot be forwarded after its retries
+const (
+	forwardFailureBlock      = "block"
+	forwardFailureDeadLetter = "dead-letter"
+)
+
+func newRetryPolicy(maxAttempts int, initialBackoff string, maxBackoff string, deadline string) (native.RetryPolicy, error) {
+	policy := native.RetryPolicy{MaxAttempts: maxAttempts}
+	var err error
+	if policy.InitialBackoff, err = time.ParseDuration(initialBackoff); err != nil {
+		return policy, fmt.Errorf("parsing initial backoff: %w", err)
+	}
+	if policy.MaxBackoff, err = time.ParseDuration(maxBackoff); err != nil {
+		return policy, fmt.Errorf("parsing max backoff: %w", err)
+	}
+	if policy.Deadline, err = time.ParseDuration(deadline); err != nil {
+		return policy, fmt.Errorf("parsing retry deadline: %w", err)
+	}
+	return policy, nil
+}
+
+func newRecorderConfig(dir string, maxSizeMB int, rotationInterval string, compress bool, retention string) (queue.RecorderConfig, error) {
+	config := queue.RecorderConfig{Dir: dir, MaxSize: int64(maxSizeMB) * 1024 * 1024, Compress: compress}
+	var err error
+	if config.RotationInterval, err = time.ParseDuration(rotationInterval); err != nil {
+		return config, fmt.Errorf("parsing rotation interval: %w", err)
+	}
+	if config.Retention, err = time.ParseDuration(retention); err != nil {
+		return config, fmt.Errorf("parsing retention: %w", err)
+	}
+	return config, nil
+}
+
+func enableHealthCheck(port string, consumer *kafka.Consumer, producer *kafka.Producer, writer native.Writer, conf *config.Watcher, mh *queue.MessageHandler, panicGuide string, logger *logger.UPPLogger) error {
+	hc := resources.NewHealthCheck(consumer, producer, writer, conf, panicGuide, logger)
+	ih := resources.NewIngestHandler(mh, conf, logger)
 
 	r := mux.NewRouter()
 	r.HandleFunc("/__health", hc.Handler())
 	r.HandleFunc(httphandlers.GTGPath, httphandlers.NewGoodToGoHandler(hc.GTG)).Methods("GET")
 	r.HandleFunc(httphandlers.BuildInfoPath, httphandlers.BuildInfoHandler).Methods("GET")
 	r.HandleFunc(httphandlers.PingPath, httphandlers.PingHandler).Methods("GET")
+	r.Handle("/metrics", promhttp.Handler()).Methods("GET")
+	r.HandleFunc("/ingest", ih.Ingest).Methods("POST")
 
 	http.Handle("/", r)
 	return http.ListenAndServe(":"+port, nil)
@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "native_ingester"
+
+var messageLabels = []string{"origin_system", "collection", "message_type"}
+
+var (
+	// MessagesConsumed counts every message handled by the ingester.
+	// The collection label is empty when the message could not be routed.
+	MessagesConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_consumed_total",
+		Help:      "Number of messages consumed.",
+	}, messageLabels)
+
+	// MessagesSkipped counts the messages whose origin system and content type are not whitelisted
+	MessagesSkipped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_skipped_total",
+		Help:      "Number of messages skipped because their combination of origin system and content type is not configured.",
+	}, messageLabels)
+
+	// MessagesFailed counts the messages that could not be processed, by the stage at which they failed
+	MessagesFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_failed_total",
+		Help:      "Number of messages that failed to be processed, by failure stage.",
+	}, append([]string{"stage"}, messageLabels...))
+
+	// MessagesUnchanged counts the messages not written in the native store because their content hash did not change
+	MessagesUnchanged = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_unchanged_total",
+		Help:      "Number of messages not written to the native store because their Native-Hash is the same as the last one written.",
+	}, messageLabels)
+
+	// MessagesSchemaInvalid counts the messages whose body does not match the JSON Schema of their config rule,
+	// by the schema mode of the rule: strict messages are rejected, lenient ones are written anyway
+	MessagesSchemaInvalid = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_schema_invalid_total",
+		Help:      "Number of messages whose body does not match the JSON Schema of their config rule, by schema mode.",
+	}, append([]string{"mode"}, messageLabels...))
+
+	// MessagesWritten counts the messages successfully written in the native store
+	MessagesWritten = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_written_total",
+		Help:      "Number of messages written to the native store.",
+	}, messageLabels)
+
+	// MessagesForwarded counts the messages successfully forwarded to the producer queue
+	MessagesForwarded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_forwarded_total",
+		Help:      "Number of messages forwarded to the producer queue.",
+	}, messageLabels)
+
+	// ForwardRetries counts the failed attempts to forward a message that were retried
+	ForwardRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "forward_retries_total",
+		Help:      "Number of failed attempts to forward a message to the producer queue that were retried.",
+	}, messageLabels)
+
+	// ForwardsBlocked is the number of messages whose forward is retried until it succeeds, which blocks their consumption
+	ForwardsBlocked = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "forwards_blocked",
+		Help:      "Number of messages retried until they are forwarded after exhausting their forward attempts, blocking consumption.",
+	})
+
+	// MessagesDryRun counts the messages that would have been written in the native store, when running in dry-run mode
+	MessagesDryRun = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_dry_run_total",
+		Help:      "Number of messages that would have been written to the native store in dry-run mode.",
+	}, messageLabels)
+
+	// HandlingDuration measures the end-to-end handling time of a consumed message
+	HandlingDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "message_handling_duration_seconds",
+		Help:      "End-to-end time spent handling a consumed message.",
+		Buckets:   prometheus.DefBuckets,
+	}, messageLabels)
+
+	// NativeWriterRequestDuration measures the latency of every single request to the native writer.
+	// The status label holds the HTTP status code, or "error" if no response was received.
+	NativeWriterRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "native_writer_request_duration_seconds",
+		Help:      "Latency of the requests to the native writer.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "collection", "status"})
+)
@@ -2,6 +2,7 @@ package mocks
 
 import (
 	"github.com/Financial-Times/kafka-client-go/v4"
+	"github.com/Financial-Times/native-ingester/config"
 	"github.com/Financial-Times/native-ingester/native"
 	"github.com/stretchr/testify/mock"
 )
@@ -50,19 +51,41 @@ func (c *ConsumerMock) Close() error {
 
 type WriterMock struct {
 	mock.Mock
+	// Parser is returned by ParserFor for every rule
+	Parser native.ContentBodyParser
 }
 
-func (w *WriterMock) GetCollection(originID string, contentType string, publication []interface{}) (string, error) {
-	args := w.Called(originID, contentType, publication)
-	return args.String(0), args.Error(1)
+// ParserFor returns a parser for the uuid_fields of the rule, or else the Parser of the mock, without recording the call,
+// so that tests do not need to expect it
+func (w *WriterMock) ParserFor(rule config.OriginSystemConfig) (native.ContentBodyParser, error) {
+	if len(rule.UUIDFields) > 0 {
+		return native.NewContentBodyParser(rule.UUIDFields)
+	}
+	return w.Parser, nil
+}
+
+// GetRule records the origin system, content type and publication of the message, that most rules route on
+func (w *WriterMock) GetRule(msg native.NativeMessage) (config.OriginSystemConfig, error) {
+	args := w.Called(msg.OriginSystemID(), msg.ContentType(), msg.Publication())
+	return args.Get(0).(config.OriginSystemConfig), args.Error(1)
 }
 
-func (w *WriterMock) WriteToCollection(msg native.NativeMessage, collection string) (string, string, error) {
+// WriteToCollection records the message and the collection, the rule being the one returned by GetRule
+func (w *WriterMock) WriteToCollection(msg native.NativeMessage, rule config.OriginSystemConfig, collection string) (string, string, int, error) {
 	args := w.Called(msg, collection)
-	return args.String(0), args.String(1), args.Error(2)
+	return args.String(0), args.String(1), args.Int(2), args.Error(3)
 }
 
 func (w *WriterMock) ConnectivityCheck() (string, error) {
 	args := w.Called()
 	return args.String(0), args.Error(1)
 }
+
+type ConfigReloaderMock struct {
+	mock.Mock
+}
+
+func (c *ConfigReloaderMock) ReloadCheck() error {
+	args := c.Called()
+	return args.Error(0)
+}
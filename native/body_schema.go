@@ -0,0 +1,60 @@
+package native
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// SchemaViolation is a value of a native message body that does not match the JSON Schema of its config rule
+type SchemaViolation struct {
+	// Pointer is the JSON pointer to the value in the body, empty for the body itself
+	Pointer string
+	Message string
+}
+
+// SchemaError is returned when a native message body does not match the JSON Schema of its config rule
+type SchemaError struct {
+	Violations []SchemaViolation
+}
+
+func (e *SchemaError) Error() string {
+	violations := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		violations = append(violations, fmt.Sprintf("%q: %s", v.Pointer, v.Message))
+	}
+	return "body does not match schema: " + strings.Join(violations, "; ")
+}
+
+// Pointers returns the JSON pointers of all the violations
+func (e *SchemaError) Pointers() []string {
+	pointers := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		pointers = append(pointers, v.Pointer)
+	}
+	return pointers
+}
+
+// ValidateBody checks the message body, including the lastModified and publishReference fields added by the ingester,
+// against the given schema. A body that does not match it gives a *SchemaError listing every violation.
+func (msg *NativeMessage) ValidateBody(schema *jsonschema.Schema) error {
+	err := schema.Validate(msg.body)
+	var validationErr *jsonschema.ValidationError
+	if !errors.As(err, &validationErr) {
+		return err
+	}
+	return &SchemaError{Violations: leafViolations(validationErr, nil)}
+}
+
+// leafViolations flattens the tree of validation errors into the innermost ones, which point at the faulty values
+func leafViolations(err *jsonschema.ValidationError, violations []SchemaViolation) []SchemaViolation {
+	if len(err.Causes) == 0 {
+		return append(violations, SchemaViolation{Pointer: err.InstanceLocation, Message: err.Message})
+	}
+	for _, cause := range err.Causes {
+		violations = leafViolations(cause, violations)
+	}
+	return violations
+}
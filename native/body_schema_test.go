@@ -0,0 +1,43 @@
+package native
+
+import (
+	"testing"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const articleSchema = `{
+	"type": "object",
+	"required": ["uuid", "title"],
+	"properties": {
+		"uuid": {"type": "string", "format": "uuid"},
+		"title": {"type": "string"},
+		"authors": {"type": "array", "items": {"type": "string"}}
+	}
+}`
+
+func TestValidateBody(t *testing.T) {
+	schema := jsonschema.MustCompileString("article.schema.json", articleSchema)
+
+	msg, err := NewNativeMessage(`{"uuid": "07ac9fad-6434-47c7-b7c4-34361a048d07", "title": "Title", "authors": ["A. Writer"]}`, "2017-02-16T12:56:16Z", "tid_test", "")
+	require.NoError(t, err)
+
+	assert.NoError(t, msg.ValidateBody(schema))
+}
+
+func TestValidateBodyReportsEveryViolation(t *testing.T) {
+	schema := jsonschema.MustCompileString("article.schema.json", articleSchema)
+
+	msg, err := NewNativeMessage(`{"uuid": "07ac9fad-6434-47c7-b7c4-34361a048d07", "authors": ["A. Writer", 42]}`, "2017-02-16T12:56:16Z", "tid_test", "")
+	require.NoError(t, err)
+
+	err = msg.ValidateBody(schema)
+
+	var schemaErr *SchemaError
+	require.ErrorAs(t, err, &schemaErr)
+	assert.ElementsMatch(t, []string{"", "/authors/1"}, schemaErr.Pointers())
+	assert.Contains(t, err.Error(), `"/authors/1": expected string, but got number`)
+	assert.Contains(t, err.Error(), `"": missing properties: 'title'`)
+}
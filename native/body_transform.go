@@ -0,0 +1,130 @@
+package native
+
+import (
+	"strings"
+
+	"github.com/Financial-Times/native-ingester/config"
+)
+
+// SetMessageHeaders keeps the headers of the consumed message, that body transforms can copy into the body.
+// They are not sent to the native writer.
+func (msg *NativeMessage) SetMessageHeaders(headers map[string]string) {
+	msg.messageHeaders = headers
+}
+
+// Transform applies the transforms of a config rule to the message body, in order, and returns how many changed it.
+// The body is copied first, so that the transforms do not change the body of the other copies of the message.
+func (msg *NativeMessage) Transform(transforms []config.Transform) int {
+	msg.body = copyValue(msg.body).(map[string]interface{})
+	applied := 0
+	for _, t := range transforms {
+		if msg.transform(t) {
+			applied++
+		}
+	}
+	return applied
+}
+
+func (msg *NativeMessage) transform(t config.Transform) bool {
+	switch {
+	case t.Remove != "":
+		parent, key, found := lookupField(msg.body, t.Remove)
+		if found {
+			delete(parent, key)
+		}
+		return found
+	case t.Rename != "":
+		parent, key, found := lookupField(msg.body, t.Rename)
+		if found {
+			value := parent[key]
+			delete(parent, key)
+			setField(msg.body, t.To, value)
+		}
+		return found
+	case t.Set != "":
+		setField(msg.body, t.Set, t.Value)
+		return true
+	case t.CopyHeader != "":
+		value, found := msg.messageHeaders[t.CopyHeader]
+		if found {
+			setField(msg.body, t.To, value)
+		}
+		return found
+	case t.Truncate != "":
+		parent, key, found := lookupField(msg.body, t.Truncate)
+		if !found {
+			return false
+		}
+		truncated, changed := truncate(parent[key], t.MaxLength)
+		parent[key] = truncated
+		return changed
+	}
+	return false
+}
+
+// lookupField returns the object holding the field at the dotted path, and the key of the field in it
+func lookupField(body map[string]interface{}, path string) (map[string]interface{}, string, bool) {
+	segments := strings.Split(path, ".")
+	parent := body
+	for _, segment := range segments[:len(segments)-1] {
+		child, ok := parent[segment].(map[string]interface{})
+		if !ok {
+			return nil, "", false
+		}
+		parent = child
+	}
+	key := segments[len(segments)-1]
+	_, found := parent[key]
+	return parent, key, found
+}
+
+// setField sets the field at the dotted path, creating the missing objects on the way and replacing any other value in it
+func setField(body map[string]interface{}, path string, value interface{}) {
+	segments := strings.Split(path, ".")
+	parent := body
+	for _, segment := range segments[:len(segments)-1] {
+		child, ok := parent[segment].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			parent[segment] = child
+		}
+		parent = child
+	}
+	parent[segments[len(segments)-1]] = value
+}
+
+// truncate shortens a string to maxLength characters, or an array to maxLength items. Other values are left as they are.
+func truncate(value interface{}, maxLength int) (interface{}, bool) {
+	switch v := value.(type) {
+	case string:
+		runes := []rune(v)
+		if len(runes) > maxLength {
+			return string(runes[:maxLength]), true
+		}
+	case []interface{}:
+		if len(v) > maxLength {
+			return v[:maxLength], true
+		}
+	}
+	return value, false
+}
+
+// copyValue returns a deep copy of a value decoded from JSON
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			c[key] = copyValue(item)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, item := range v {
+			c[i] = copyValue(item)
+		}
+		return c
+	default:
+		return v
+	}
+}
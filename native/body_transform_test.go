@@ -0,0 +1,96 @@
+package native
+
+import (
+	"testing"
+
+	"github.com/Financial-Times/native-ingester/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransform(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		transform   config.Transform
+		wantBody    map[string]interface{}
+		wantApplied bool
+	}{
+		{
+			"remove nested field",
+			`{"a": {"b": 1, "c": 2}}`,
+			config.Transform{Remove: "a.b"},
+			map[string]interface{}{"a": map[string]interface{}{"c": 2.0}},
+			true,
+		},
+		{
+			"remove missing field",
+			`{"a": 1}`,
+			config.Transform{Remove: "a.b"},
+			map[string]interface{}{"a": 1.0},
+			false,
+		},
+		{
+			"rename into new object",
+			`{"a": "x"}`,
+			config.Transform{Rename: "a", To: "b.c"},
+			map[string]interface{}{"b": map[string]interface{}{"c": "x"}},
+			true,
+		},
+		{
+			"set replaces value",
+			`{"a": "x"}`,
+			config.Transform{Set: "a", Value: []interface{}{"y"}},
+			map[string]interface{}{"a": []interface{}{"y"}},
+			true,
+		},
+		{
+			"copy missing header",
+			`{}`,
+			config.Transform{CopyHeader: "X-Missing", To: "a"},
+			map[string]interface{}{},
+			false,
+		},
+		{
+			"truncate string by characters",
+			`{"a": "héllo wörld"}`,
+			config.Transform{Truncate: "a", MaxLength: 7},
+			map[string]interface{}{"a": "héllo w"},
+			true,
+		},
+		{
+			"truncate array",
+			`{"a": [1, 2, 3]}`,
+			config.Transform{Truncate: "a", MaxLength: 2},
+			map[string]interface{}{"a": []interface{}{1.0, 2.0}},
+			true,
+		},
+		{
+			"truncate short string",
+			`{"a": "short"}`,
+			config.Transform{Truncate: "a", MaxLength: 10},
+			map[string]interface{}{"a": "short"},
+			false,
+		},
+		{
+			"truncate ignores other values",
+			`{"a": 12345}`,
+			config.Transform{Truncate: "a", MaxLength: 2},
+			map[string]interface{}{"a": 12345.0},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := NewNativeMessage(tt.body, "", "", "")
+			require.NoError(t, err)
+			delete(msg.body, "lastModified")
+			delete(msg.body, "publishReference")
+
+			applied := msg.Transform([]config.Transform{tt.transform})
+
+			assert.Equal(t, tt.wantApplied, applied == 1)
+			assert.Equal(t, tt.wantBody, msg.body)
+		})
+	}
+}
@@ -1,35 +1,162 @@
 package native
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 
 	uuidParser "github.com/google/uuid"
-	"github.com/jmoiron/jsonq"
+	"github.com/jmespath/go-jmespath"
 )
 
 // ContentBodyParser parses the body of native content
 type ContentBodyParser interface {
-	getUUID(body map[string]interface{}) (string, error)
+	getUUID(body map[string]interface{}) (string, uuidMatch, error)
+	withPaths(uuidJSONPaths []string) (ContentBodyParser, error)
+}
+
+// uuidMatch describes where a content UUID was found, and the derivation rule that produced it, if any
+type uuidMatch struct {
+	path string
+	rule string
 }
 
 type contentBodyParser struct {
-	uuidJSONPaths []string
+	uuidPaths   []uuidPath
+	derivations map[string]*uuidDerivation
+}
+
+// uuidPath is a JMESPath expression, or the segments of a plain dotted path
+type uuidPath struct {
+	path       string
+	expression *jmespath.JMESPath
+	segments   []string
+	derivation *uuidDerivation
+}
+
+// dottedPath matches the plain dotted paths (e.g. post.uuid or items.0.uuid) used before JMESPath expressions were supported
+var dottedPath = regexp.MustCompile(`^[\w\-$]+(\.[\w\-$]+)*$`)
+
+// NewContentBodyParser returns a new instance of a ContentBodyParser looking for the content UUID at the given
+// JMESPath expressions, in order. Plain dotted paths are matched as they always have been,
+// with numeric segments used as array indexes in arrays and as keys in objects.
+// Derivations, in the form path=rule, turn the identifiers found at some of these paths into UUIDs (see parseUUIDDerivation).
+func NewContentBodyParser(uuidJSONPaths []string, derivations ...string) (ContentBodyParser, error) {
+	var errs []error
+	rules := make(map[string]*uuidDerivation)
+	for _, spec := range derivations {
+		path, derivation, err := parseUUIDDerivationSpec(spec)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !slices.Contains(uuidJSONPaths, path) {
+			errs = append(errs, fmt.Errorf("UUID derivation %q: %q is not one of the UUID paths", spec, path))
+			continue
+		}
+		rules[path] = derivation
+	}
+
+	p, err := newContentBodyParser(uuidJSONPaths, rules)
+	return p, errors.Join(append(errs, err)...)
+}
+
+func newContentBodyParser(uuidJSONPaths []string, derivations map[string]*uuidDerivation) (*contentBodyParser, error) {
+	p := &contentBodyParser{derivations: derivations}
+	var errs []error
+	for _, path := range uuidJSONPaths {
+		if dottedPath.MatchString(path) {
+			p.uuidPaths = append(p.uuidPaths, uuidPath{path: path, segments: strings.Split(path, "."), derivation: derivations[path]})
+			continue
+		}
+		expression, err := jmespath.Compile(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid UUID path %q: %w", path, err))
+			continue
+		}
+		p.uuidPaths = append(p.uuidPaths, uuidPath{path: path, expression: expression, derivation: derivations[path]})
+	}
+	return p, errors.Join(errs...)
 }
 
-// NewContentBodyParser returns a new instace of a ContentBodyParser
-func NewContentBodyParser(uuidJSONPaths []string) ContentBodyParser {
-	return &contentBodyParser{uuidJSONPaths}
+// withPaths returns a parser looking for the UUID at other paths, with the same derivation rules
+func (p contentBodyParser) withPaths(uuidJSONPaths []string) (ContentBodyParser, error) {
+	return newContentBodyParser(uuidJSONPaths, p.derivations)
 }
 
-func (p contentBodyParser) getUUID(body map[string]interface{}) (string, error) {
-	jq := jsonq.NewQuery(body)
-	for _, uuidPath := range p.uuidJSONPaths {
-		uuid, jsonPathErr := jq.String(strings.Split(uuidPath, ".")...)
-		_, uuidParsingError := uuidParser.Parse(uuid)
-		if jsonPathErr == nil && uuidParsingError == nil {
-			return uuid, nil
+func (p contentBodyParser) getUUID(body map[string]interface{}) (string, uuidMatch, error) {
+	for _, path := range p.uuidPaths {
+		value, err := path.search(body)
+		if err != nil {
+			continue
 		}
+		// plain dotted paths only ever matched a single string
+		if _, isList := value.([]interface{}); isList && path.segments != nil {
+			continue
+		}
+		if path.derivation != nil {
+			if uuid, found := path.derivation.apply(value); found {
+				return uuid, uuidMatch{path.path, path.derivation.rule}, nil
+			}
+			continue
+		}
+		if uuid, found := firstUUID(value); found {
+			return uuid, uuidMatch{path: path.path}, nil
+		}
+	}
+	return "", uuidMatch{}, errors.New("UUID not found")
+}
+
+// search returns the value at the path in the body. The segments of plain dotted paths are keys in objects
+// and indexes in arrays, so that numeric keys keep working.
+func (p uuidPath) search(body map[string]interface{}) (interface{}, error) {
+	if p.segments == nil {
+		return p.expression.Search(body)
+	}
+	var value interface{} = body
+	for _, segment := range p.segments {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			value = v[segment]
+		case []interface{}:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(v) {
+				return nil, fmt.Errorf("no index %s in array", segment)
+			}
+			value = v[index]
+		default:
+			return nil, fmt.Errorf("no field %s in %v", segment, value)
+		}
+	}
+	return value, nil
+}
+
+// firstUUID returns the value if it is a UUID, or the first UUID in it if it is the list produced by a projection
+func firstUUID(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		_, err := uuidParser.Parse(v)
+		return v, err == nil
+	case []interface{}:
+		for _, item := range v {
+			if uuid, found := firstUUID(item); found {
+				return uuid, true
+			}
+		}
+	}
+	return "", false
+}
+
+// ExtractUUID returns the UUID the given parser finds in a raw JSON content body
+func ExtractUUID(p ContentBodyParser, contentBody string) (string, error) {
+	body := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(contentBody), &body); err != nil {
+		return "", err
 	}
-	return "", errors.New("UUID not found")
+	uuid, _, err := p.getUUID(body)
+	return uuid, err
 }
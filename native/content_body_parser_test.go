@@ -71,10 +71,11 @@ var unhappyTests = []struct {
 
 func TestExtractUUIDSuccessfully(t *testing.T) {
 	for _, test := range happyTests {
-		bodyParser := NewContentBodyParser(test.paths)
+		bodyParser, err := NewContentBodyParser(test.paths)
+		assert.NoError(t, err)
 		body := make(map[string]interface{})
 		json.Unmarshal([]byte(test.msgBody), &body)
-		actualUUID, err := bodyParser.getUUID(body)
+		actualUUID, _, err := bodyParser.getUUID(body)
 		assert.NoError(t, err, "The parsing should not return an error")
 		assert.Equal(t, test.expectedUUID, actualUUID, "The UUIDs should be the same")
 	}
@@ -82,10 +83,76 @@ func TestExtractUUIDSuccessfully(t *testing.T) {
 
 func TestExtractUUIDFailure(t *testing.T) {
 	for _, test := range unhappyTests {
-		bodyParser := NewContentBodyParser(test.paths)
+		bodyParser, err := NewContentBodyParser(test.paths)
+		assert.NoError(t, err)
 		body := make(map[string]interface{})
 		json.Unmarshal([]byte(test.msgBody), &body)
-		_, err := bodyParser.getUUID(body)
+		_, _, err = bodyParser.getUUID(body)
 		assert.Error(t, err, "The parsing should return an error")
 	}
 }
+
+func TestExtractUUIDFromRawBody(t *testing.T) {
+	for _, test := range happyTests {
+		bodyParser, err := NewContentBodyParser(test.paths)
+		assert.NoError(t, err)
+		actualUUID, err := ExtractUUID(bodyParser, test.msgBody)
+		assert.NoError(t, err, "The parsing should not return an error")
+		assert.Equal(t, test.expectedUUID, actualUUID, "The UUIDs should be the same")
+	}
+
+	bodyParser, err := NewContentBodyParser([]string{"uuid"})
+	assert.NoError(t, err)
+	_, err = ExtractUUID(bodyParser, "I am not JSON")
+	assert.Error(t, err, "The parsing should return an error for invalid JSON")
+}
+
+func TestExtractUUIDWithJMESPath(t *testing.T) {
+	body := `{
+		"items": [
+			{"type": "image", "uuid": "a1b2c3d4-0000-4000-8000-000000000001"},
+			{"type": "article", "uuid": "07ac9fad-6434-47c7-b7c4-34361a048d07"}
+		],
+		"ids": ["not-a-uuid", "a1b2c3d4-0000-4000-8000-000000000002"],
+		"my.key": {"uuid": "a1b2c3d4-0000-4000-8000-000000000003"},
+		"data": {"uuid-v3": "a1b2c3d4-0000-4000-8000-000000000004"},
+		"years": {"2019": {"uuid": "a1b2c3d4-0000-4000-8000-000000000005"}}
+	}`
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{"array index", "items[1].uuid", "07ac9fad-6434-47c7-b7c4-34361a048d07"},
+		{"dotted array index", "items.1.uuid", "07ac9fad-6434-47c7-b7c4-34361a048d07"},
+		{"filter", "items[?type=='article'].uuid", "07ac9fad-6434-47c7-b7c4-34361a048d07"},
+		{"first UUID of a projection", "ids[*]", "a1b2c3d4-0000-4000-8000-000000000002"},
+		{"key with a dot", `"my.key".uuid`, "a1b2c3d4-0000-4000-8000-000000000003"},
+		{"dotted key with a hyphen", "data.uuid-v3", "a1b2c3d4-0000-4000-8000-000000000004"},
+		{"dotted numeric object key", "years.2019.uuid", "a1b2c3d4-0000-4000-8000-000000000005"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bodyParser, err := NewContentBodyParser([]string{"uuid", tt.path})
+			assert.NoError(t, err)
+			actualUUID, err := ExtractUUID(bodyParser, body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, actualUUID)
+		})
+	}
+}
+
+func TestDottedPathDoesNotMatchList(t *testing.T) {
+	bodyParser, err := NewContentBodyParser([]string{"ids"})
+	assert.NoError(t, err)
+	_, err = ExtractUUID(bodyParser, `{"ids": ["07ac9fad-6434-47c7-b7c4-34361a048d07"]}`)
+	assert.Error(t, err, "A plain dotted path should only match a single UUID, as before JMESPath support")
+}
+
+func TestInvalidUUIDPaths(t *testing.T) {
+	_, err := NewContentBodyParser([]string{"uuid", "items[0", "items[?type==]"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid UUID path "items[0"`)
+	assert.Contains(t, err.Error(), `invalid UUID path "items[?type==]"`)
+	assert.NotContains(t, err.Error(), `"uuid"`)
+}
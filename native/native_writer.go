@@ -2,16 +2,23 @@ package native
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/Financial-Times/go-logger/v2"
 	"github.com/Financial-Times/native-ingester/config"
+	"github.com/Financial-Times/native-ingester/metrics"
 	"github.com/Financial-Times/service-status-go/httphandlers"
+	"github.com/jmoiron/jsonq"
 )
 
 const (
@@ -21,6 +28,7 @@ const (
 	originSystemIDHeader               = "Origin-System-Id"
 	messageTypeHeader                  = "Message-Type"
 	messageTypePartialContentPublished = "cms-partial-content-published"
+	messageTypeContentDeleted          = "cms-content-deleted"
 	schemaVersionHeader                = "X-Schema-Version"
 	contentRevisionHeader              = "X-Content-Revision"
 	publicationBodyField               = "publication"
@@ -28,59 +36,154 @@ const (
 
 // Writer provides the functionalities to write in the native store
 type Writer interface {
-	GetCollection(originID string, contentType string, publication []interface{}) (string, error)
-	WriteToCollection(msg NativeMessage, collection string) (string, string, error)
+	GetRule(msg NativeMessage) (config.OriginSystemConfig, error)
+	// WriteToCollection writes the message to one of the collections of the rule routing it, using the uuid_fields and transforms of
+	// that rule, and returns its content UUID, the content returned by the native writer and the status of its response
+	WriteToCollection(msg NativeMessage, rule config.OriginSystemConfig, collection string) (string, string, int, error)
+	// ParserFor returns the parser finding the content UUID of the messages routed by the rule
+	ParserFor(rule config.OriginSystemConfig) (ContentBodyParser, error)
 	ConnectivityCheck() (string, error)
 }
 
+// ErrStaleContent is returned by the writer when the native store already holds a newer revision of the content
+var ErrStaleContent = errors.New("stale content")
+
+// UUIDExtractionError is returned by the writer when the content UUID cannot be found in the message body
+type UUIDExtractionError struct {
+	Err error
+}
+
+func (e *UUIDExtractionError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *UUIDExtractionError) Unwrap() error {
+	return e.Err
+}
+
 type nativeWriter struct {
 	address     string
-	collections config.Configuration
+	collections config.Provider
 	httpClient  http.Client
 	bodyParser  ContentBodyParser
+	rulePaths   sync.Map
+	retryPolicy RetryPolicy
 	logger      *logger.UPPLogger
 }
 
 // NewWriter returns a new instance of a native writer
-func NewWriter(address string, collectionsOriginIdsMap config.Configuration, parser ContentBodyParser, logger *logger.UPPLogger) Writer {
-	collections := collectionsOriginIdsMap
-	return &nativeWriter{address, collections, http.Client{}, parser, logger}
+func NewWriter(address string, collections config.Provider, parser ContentBodyParser, retryPolicy RetryPolicy, logger *logger.UPPLogger) Writer {
+	return &nativeWriter{address: address, collections: collections, bodyParser: parser, retryPolicy: retryPolicy, logger: logger}
 }
 
-func (nw *nativeWriter) GetCollection(originID string, contentType string, publication []interface{}) (string, error) {
-	return nw.collections.GetCollection(originID, contentType, publication)
+// GetRule returns the config rule routing the message to its collections
+func (nw *nativeWriter) GetRule(msg NativeMessage) (config.OriginSystemConfig, error) {
+	rule, _, err := nw.collections.Current().Route(msg.Routing())
+	return rule, err
 }
 
-func (nw *nativeWriter) WriteToCollection(msg NativeMessage, collection string) (string, string, error) {
-	contentUUID, err := nw.bodyParser.getUUID(msg.body)
+func (nw *nativeWriter) WriteToCollection(msg NativeMessage, rule config.OriginSystemConfig, collection string) (string, string, int, error) {
+	parser, err := nw.ParserFor(rule)
+	if err != nil {
+		nw.logger.WithTransactionID(msg.TransactionID()).WithError(err).Error("Error compiling the UUID fields of the matching config rule. Ignoring message.")
+		return "", "", 0, &UUIDExtractionError{err}
+	}
+	contentUUID, match, err := parser.getUUID(msg.body)
 
 	log := nw.logger.WithTransactionID(msg.TransactionID()).WithUUID(contentUUID)
 
 	if err != nil {
 		log.WithError(err).Error("Error extracting uuid. Ignoring message.")
-		return contentUUID, "", err
+		return contentUUID, "", 0, &UUIDExtractionError{err}
+	}
+	if match.rule != "" {
+		log.WithField("uuid_path", match.path).WithField("uuid_rule", match.rule).Infof("Derived content UUID from %s with rule %s", match.path, match.rule)
 	}
 	log.Info("Start processing native publish event")
+	if len(rule.Transforms) > 0 && !msg.IsDelete() {
+		applied := msg.Transform(rule.Transforms)
+		log.WithField("collection", collection).Infof("Applied %d of the %d body transforms of the matching config rule", applied, len(rule.Transforms))
+	}
 	cBodyAsJSON, err := json.Marshal(msg.body)
 
 	if err != nil {
 		log.WithError(err).Error("Error marshalling message")
-		return contentUUID, "", err
+		return contentUUID, "", 0, err
 	}
 
 	requestURL := nw.address + "/" + collection + "/" + contentUUID
-	httpMethod := "POST"
+	httpMethod := msg.WriteMethod()
+	if msg.IsDelete() {
+		cBodyAsJSON = nil
+	}
+
+	// the requests carry the remaining retry deadline, so that a hanging native writer does not block the message forever
+	ctx := context.Background()
+	var deadline time.Time
+	if nw.retryPolicy.Deadline > 0 {
+		deadline = time.Now().Add(nw.retryPolicy.Deadline)
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	maxAttempts := nw.retryPolicy.Attempts()
+
+	for attempt := 1; ; attempt++ {
+		attemptLog := log.WithField("attempt", attempt)
+		attemptLog.Infof("Calling native writer (attempt %d of %d)", attempt, maxAttempts)
+
+		updatedContent, status, retryable, err := nw.callNativeWriter(ctx, httpMethod, requestURL, collection, cBodyAsJSON, msg.headers, attemptLog)
+		if err == nil {
+			log.Info("Successfully finished processing native publish event")
+			return contentUUID, updatedContent, status, nil
+		}
+		if !retryable || attempt >= maxAttempts {
+			return contentUUID, "", status, err
+		}
+
+		wait := nw.retryPolicy.Backoff(attempt)
+		if !deadline.IsZero() && time.Now().Add(wait).After(deadline) {
+			attemptLog.WithError(err).Error("Retry deadline for native writer exceeded. Ignoring message.")
+			return contentUUID, "", status, err
+		}
+		attemptLog.WithError(err).Warnf("Retrying call to native writer in %v", wait)
+		time.Sleep(wait)
+	}
+}
+
+// ParserFor returns the parser looking for the content UUID at the uuid_fields of the config rule,
+// or at the global UUID paths if the rule has none
+func (nw *nativeWriter) ParserFor(rule config.OriginSystemConfig) (ContentBodyParser, error) {
+	if len(rule.UUIDFields) == 0 {
+		return nw.bodyParser, nil
+	}
+
+	key := strings.Join(rule.UUIDFields, "\n")
+	if parser, found := nw.rulePaths.Load(key); found {
+		return parser.(ContentBodyParser), nil
+	}
+	parser, err := nw.bodyParser.withPaths(rule.UUIDFields)
+	if err != nil {
+		return nil, err
+	}
+	nw.rulePaths.Store(key, parser)
+	return parser, nil
+}
 
-	if msg.IsPartialContent() {
-		httpMethod = "PATCH"
+// callNativeWriter performs a single request to the native writer, returns the content and status of its response,
+// and reports whether a failure is worth retrying
+func (nw *nativeWriter) callNativeWriter(ctx context.Context, httpMethod string, requestURL string, collection string, body []byte, headers map[string]string, log *logger.LogEntry) (string, int, bool, error) {
+	var requestBody io.Reader
+	if body != nil {
+		requestBody = bytes.NewReader(body)
 	}
-	request, err := http.NewRequest(httpMethod, requestURL, bytes.NewBuffer(cBodyAsJSON))
+	request, err := http.NewRequestWithContext(ctx, httpMethod, requestURL, requestBody)
 	if err != nil {
 		log.WithError(err).Error("Error calling native writer. Ignoring message.")
-		return contentUUID, "", err
+		return "", 0, false, err
 	}
 
-	for header, value := range msg.headers {
+	for header, value := range headers {
 		request.Header.Set(header, value)
 	}
 
@@ -96,25 +199,39 @@ func (nw *nativeWriter) WriteToCollection(msg NativeMessage, collection string)
 		log.
 			Warn("Native-save request does not have Origin-System-ID header")
 	}
+	start := time.Now()
 	response, err := nw.httpClient.Do(request)
 
 	if err != nil {
-		log.WithError(err).Error("Error calling native writer. Ignoring message.")
-		return contentUUID, "", err
+		metrics.NativeWriterRequestDuration.WithLabelValues(httpMethod, collection, "error").Observe(time.Since(start).Seconds())
+		log.WithError(err).Error("Error calling native writer.")
+		return "", 0, true, err
 	}
 	defer properClose(response, log)
+	metrics.NativeWriterRequestDuration.WithLabelValues(httpMethod, collection, strconv.Itoa(response.StatusCode)).Observe(time.Since(start).Seconds())
+
+	if response.StatusCode == http.StatusConflict {
+		log.WithError(ErrStaleContent).WithField("status", response.StatusCode).Warn("Native writer rejected the content as stale")
+		return "", response.StatusCode, false, fmt.Errorf("%w: native writer returned %d", ErrStaleContent, response.StatusCode)
+	}
+
+	if httpMethod == "DELETE" && response.StatusCode == http.StatusNotFound {
+		log.WithField("status", response.StatusCode).Info("Content to delete is not in the native store")
+		return "", response.StatusCode, false, nil
+	}
 
 	if isNot2XXStatusCode(response.StatusCode) {
 		errMsg := "Native writer returned non-200 code"
 		err := errors.New(errMsg)
-		log.WithError(err).Error(errMsg)
-		return contentUUID, "", err
+		log.WithError(err).WithField("status", response.StatusCode).Error(errMsg)
+		return "", response.StatusCode, isRetryableStatusCode(response.StatusCode), err
 	}
 
-	body, err := ioutil.ReadAll(response.Body)
-	updatedContent := string(body)
-	log.Info("Successfully finished processing native publish event")
-	return contentUUID, updatedContent, nil
+	responseBody, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		log.WithError(err).Warn("Couldn't read native writer response body")
+	}
+	return string(responseBody), response.StatusCode, false, nil
 }
 
 func properClose(resp *http.Response, log *logger.LogEntry) {
@@ -132,7 +249,7 @@ func isNot2XXStatusCode(statusCode int) bool {
 	return statusCode < 200 || statusCode >= 300
 }
 
-func (nw nativeWriter) ConnectivityCheck() (string, error) {
+func (nw *nativeWriter) ConnectivityCheck() (string, error) {
 	req, err := http.NewRequest("GET", nw.address+httphandlers.GTGPath, nil)
 	if err != nil {
 		return "Error in building request to check if the native writer is good to go", err
@@ -153,8 +270,9 @@ func (nw nativeWriter) ConnectivityCheck() (string, error) {
 
 // NativeMessage is the message accepted by the native writer
 type NativeMessage struct {
-	body    map[string]interface{}
-	headers map[string]string
+	body           map[string]interface{}
+	headers        map[string]string
+	messageHeaders map[string]string
 }
 
 // NewNativeMessage returns a new instance of a NativeMessage
@@ -167,7 +285,7 @@ func NewNativeMessage(contentBody string, timestamp string, transactionID string
 	body["lastModified"] = timestamp
 	body["publishReference"] = transactionID
 
-	msg := NativeMessage{body, make(map[string]string)}
+	msg := NativeMessage{body: body, headers: make(map[string]string)}
 	msg.headers[transactionIDHeader] = transactionID
 	msg.headers[messageTypeHeader] = messageType
 
@@ -179,6 +297,11 @@ func (msg *NativeMessage) AddHashHeader(hash string) {
 	msg.headers[nativeHashHeader] = hash
 }
 
+// NativeHash returns the hash of the native content, if the publisher provided it
+func (msg *NativeMessage) NativeHash() string {
+	return msg.headers[nativeHashHeader]
+}
+
 // AddContentTypeHeader adds the content type of the native content as a header
 func (msg *NativeMessage) AddContentTypeHeader(contentType string) {
 	msg.headers[contentTypeHeader] = contentType
@@ -208,6 +331,10 @@ func (msg *NativeMessage) OriginSystemID() string {
 	return msg.headers[originSystemIDHeader]
 }
 
+func (msg *NativeMessage) MessageType() string {
+	return msg.headers[messageTypeHeader]
+}
+
 func (msg *NativeMessage) SchemaVersion() string {
 	return msg.headers[schemaVersionHeader]
 }
@@ -220,6 +347,71 @@ func (msg *NativeMessage) IsPartialContent() bool {
 	return msg.headers[messageTypeHeader] == messageTypePartialContentPublished
 }
 
+// IsDelete returns true if the message requests the removal of the content from the native store
+func (msg *NativeMessage) IsDelete() bool {
+	return msg.headers[messageTypeHeader] == messageTypeContentDeleted
+}
+
+// MarkAsDelete turns the message into a request to remove the content from the native store
+func (msg *NativeMessage) MarkAsDelete() {
+	msg.headers[messageTypeHeader] = messageTypeContentDeleted
+}
+
+// WriteMethod returns the HTTP method used to write the message in the native store
+func (msg *NativeMessage) WriteMethod() string {
+	switch {
+	case msg.IsDelete():
+		return "DELETE"
+	case msg.IsPartialContent():
+		return "PATCH"
+	default:
+		return "POST"
+	}
+}
+
+// ContentUUID returns the UUID the given parser finds in the message body
+func (msg *NativeMessage) ContentUUID(p ContentBodyParser) (string, error) {
+	uuid, _, err := p.getUUID(msg.body)
+	return uuid, err
+}
+
+// HasBodyMarker checks the body against a marker in the form "path" or "path=value".
+// Without a value the field at the dotted path must be the boolean true,
+// otherwise its string representation must be equal to the given value.
+func (msg *NativeMessage) HasBodyMarker(marker string) bool {
+	if marker == "" {
+		return false
+	}
+	path, expected, hasValue := strings.Cut(marker, "=")
+
+	value, err := jsonq.NewQuery(msg.body).Interface(strings.Split(path, ".")...)
+	if err != nil {
+		return false
+	}
+	if !hasValue {
+		flag, ok := value.(bool)
+		return ok && flag
+	}
+	return fmt.Sprint(value) == expected
+}
+
+// JSONBody returns the body of the message as JSON, with the lastModified and publishReference fields added to it
+func (msg *NativeMessage) JSONBody() (string, error) {
+	body, err := json.Marshal(msg.body)
+	return string(body), err
+}
+
+// Routing returns what the config rules are matched against: the origin system, the content type,
+// the headers of the consumed message and the body
+func (msg *NativeMessage) Routing() config.Message {
+	return config.Message{
+		OriginSystemID: strings.TrimSpace(msg.OriginSystemID()),
+		ContentType:    msg.ContentType(),
+		Headers:        msg.messageHeaders,
+		Body:           msg.body,
+	}
+}
+
 func (msg *NativeMessage) Publication() []interface{} {
 	publication, exists := msg.body[publicationBodyField]
 	if !exists {
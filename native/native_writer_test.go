@@ -1,11 +1,15 @@
 package native
 
 import (
+	"context"
 	"errors"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/Financial-Times/go-logger/v2"
 
@@ -100,19 +104,37 @@ func getConfig(str string) (*config.Configuration, error) {
 	return config.ReadConfigFromReader(ior)
 }
 
+func routedMsg(originID string, contentType string) NativeMessage {
+	msg, _ := NewNativeMessage("{}", aTimestamp, publishRef, messageTypeContentPublished)
+	msg.AddOriginSystemIDHeader(originID)
+	msg.AddContentTypeHeader(contentType)
+	return msg
+}
+
+// ruleOf returns the rule routing the message, or no rule at all if it is not routed, as the handler would pass it to WriteToCollection
+func ruleOf(w Writer, msg NativeMessage) config.OriginSystemConfig {
+	rule, _ := w.GetRule(msg)
+	return rule
+}
+
+func getCollection(w Writer, msg NativeMessage) (string, error) {
+	rule, err := w.GetRule(msg)
+	return rule.Collection, err
+}
+
 func TestGetCollectionShort(t *testing.T) {
 	log := logger.NewUPPLogger("native_writer_test", "DEBUG")
 	p := new(ContentBodyParserMock)
 	testCollectionsOriginIdsMap, err := getConfig(strCollectionsOriginIdsMap)
 	assert.NoError(t, err, "It should not return an error")
 
-	w := NewWriter("", *testCollectionsOriginIdsMap, p, log)
+	w := NewWriter("", testCollectionsOriginIdsMap, p, NoRetryPolicy, log)
 
-	actualCollection, err := w.GetCollection(cctOriginSystemID, aContentType, nil)
+	actualCollection, err := getCollection(w, routedMsg(cctOriginSystemID, aContentType))
 	assert.NoError(t, err, "It should not return an error")
 	assert.Equal(t, universalContentCollectionName, actualCollection, "It should return the universal-content collection")
 
-	_, err = w.GetCollection("Origin-Id-that-do-not-exist", aContentType, nil)
+	_, err = getCollection(w, routedMsg("Origin-Id-that-do-not-exist", aContentType))
 	assert.EqualError(t, err, "origin system not found", "It should return a collection not found error")
 	p.AssertExpectations(t)
 }
@@ -123,7 +145,7 @@ func TestGetCollection(t *testing.T) {
 	p := new(ContentBodyParserMock)
 	testCollectionsOriginIdsMap, err := getConfig(strCollectionsOriginIdsMap)
 	assert.NoError(t, err, "It should not return an error")
-	w := NewWriter("", *testCollectionsOriginIdsMap, p, log)
+	w := NewWriter("", testCollectionsOriginIdsMap, p, NoRetryPolicy, log)
 
 	tests := []struct {
 		contentType   string
@@ -147,7 +169,7 @@ func TestGetCollection(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run("Test", func(t *testing.T) {
-			actualCollection, err := w.GetCollection(cctOriginSystemID, tt.contentType, nil)
+			actualCollection, err := getCollection(w, routedMsg(cctOriginSystemID, tt.contentType))
 			if (err != nil) != tt.wantErr {
 				t.Errorf("TestGetVideoCollection() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -175,7 +197,7 @@ func TestGetAllCollection(t *testing.T) {
 	}`
 	testCollectionsOriginIdsMap, err := getConfig(str)
 	assert.NoError(t, err, "It should not return an error")
-	w := NewWriter("", *testCollectionsOriginIdsMap, p, log)
+	w := NewWriter("", testCollectionsOriginIdsMap, p, NoRetryPolicy, log)
 
 	tests := []struct {
 		contentType   string
@@ -199,7 +221,7 @@ func TestGetAllCollection(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run("Test", func(t *testing.T) {
-			actualCollection, err := w.GetCollection(cctOriginSystemID, tt.contentType, nil)
+			actualCollection, err := getCollection(w, routedMsg(cctOriginSystemID, tt.contentType))
 			if (err != nil) != tt.wantErr {
 				t.Errorf("TestGetVideoCollection() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -212,13 +234,40 @@ func TestGetAllCollection(t *testing.T) {
 		})
 	}
 }
+
+func TestGetCollectionByHeadersAndBody(t *testing.T) {
+	log := logger.NewUPPLogger("native_writer_test", "DEBUG")
+	conf, err := getConfig(`{
+		"http://cmdb.ft.com/systems/cct": [
+			{"content_type": ".*", "collection": "universal-content"},
+			{"content_type": ".*", "collection": "pages", "priority": 1, "headers": {"Message-Type": "^cms-content-published$"}, "body": [{"path": "type", "equals": "page"}]}
+		]
+	}`)
+	assert.NoError(t, err, "It should not return an error")
+	w := NewWriter("", conf, new(ContentBodyParserMock), NoRetryPolicy, log)
+
+	msg, err := NewNativeMessage(`{"type": "page"}`, aTimestamp, publishRef, messageTypeContentPublished)
+	assert.NoError(t, err, "It should not return an error by creating a message")
+	msg.AddOriginSystemIDHeader(" " + cctOriginSystemID)
+	msg.AddContentTypeHeader(aContentType)
+
+	collection, err := getCollection(w, msg)
+	assert.NoError(t, err, "It should not return an error")
+	assert.Equal(t, "universal-content", collection, "Header conditions should not match without the headers of the consumed message")
+
+	msg.SetMessageHeaders(map[string]string{"Message-Type": messageTypeContentPublished})
+	collection, err = getCollection(w, msg)
+	assert.NoError(t, err, "It should not return an error")
+	assert.Equal(t, "pages", collection)
+}
+
 func TestGetVideoCollection(t *testing.T) {
 	log := logger.NewUPPLogger("native_writer_test", "DEBUG")
 
 	p := new(ContentBodyParserMock)
 	testCollectionsOriginIdsMap, err := getConfig(audioStrCollectionsOriginIdsMap)
 	assert.NoError(t, err, "It should not return an error")
-	w := NewWriter("", *testCollectionsOriginIdsMap, p, log)
+	w := NewWriter("", testCollectionsOriginIdsMap, p, NoRetryPolicy, log)
 	o := "http://cmdb.ft.com/systems/next-video-editor"
 
 	tests := []struct {
@@ -255,7 +304,7 @@ func TestGetVideoCollection(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run("Test", func(t *testing.T) {
-			actualCollection, err := w.GetCollection(o, tt.contentType, nil)
+			actualCollection, err := getCollection(w, routedMsg(o, tt.contentType))
 			if (err != nil) != tt.wantErr {
 				t.Errorf("TestGetVideoCollection() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -282,14 +331,131 @@ func TestWriteMessageToCollectionWithSuccess(t *testing.T) {
 	msg.AddContentTypeHeader(aContentType)
 	assert.NoError(t, err, "It should not return an error by creating a message")
 
-	w := NewWriter(nws.URL, *testCollectionsOriginIdsMap, p, log)
-	contentUUID, _, err := w.WriteToCollection(msg, universalContentCollectionName)
+	w := NewWriter(nws.URL, testCollectionsOriginIdsMap, p, NoRetryPolicy, log)
+	contentUUID, _, status, err := w.WriteToCollection(msg, ruleOf(w, msg), universalContentCollectionName)
 
 	assert.NoError(t, err, "It should not return an error")
 	assert.Equal(t, aUUID, contentUUID)
+	assert.Equal(t, http.StatusOK, status, "It should return the status of the native writer response")
 	p.AssertExpectations(t)
 }
 
+func TestWriteMessageToCollectionUsesUUIDFieldsOfMatchingRule(t *testing.T) {
+	log := logger.NewUPPLogger("native_writer_test", "DEBUG")
+	conf, err := getConfig(`{
+		"http://cmdb.ft.com/systems/cct": [
+			{
+				"content_type": "(application/json).*",
+				"collection": "universal-content",
+				"uuid_fields": ["items[0].id", "id"]
+			},
+			{
+				"content_type": ".*",
+				"collection": "universal-content"
+			}
+		]
+	}`)
+	assert.NoError(t, err, "It should not return an error")
+	p, err := NewContentBodyParser([]string{"uuid"})
+	assert.NoError(t, err, "It should not return an error")
+
+	nws := setupMockNativeWriterService(t, 200, withoutNativeHashHeader, "POST", universalContentCollectionName)
+	defer nws.Close()
+	w := NewWriter(nws.URL, conf, p, NoRetryPolicy, log)
+
+	body := `{"uuid": "07ac9fad-6434-47c7-b7c4-34361a048d07", "id": "` + aUUID + `"}`
+	msg, err := NewNativeMessage(body, aTimestamp, publishRef, messageTypeContentPublished)
+	assert.NoError(t, err, "It should not return an error by creating a message")
+	msg.AddContentTypeHeader(aContentType)
+	msg.AddOriginSystemIDHeader(cctOriginSystemID)
+
+	contentUUID, _, _, err := w.WriteToCollection(msg, ruleOf(w, msg), universalContentCollectionName)
+	assert.NoError(t, err, "It should not return an error")
+	assert.Equal(t, aUUID, contentUUID, "The UUID fields of the matching rule should take precedence over the global ones")
+}
+
+func TestWriteMessageToCollectionUsesGivenRule(t *testing.T) {
+	log := logger.NewUPPLogger("native_writer_test", "DEBUG")
+	conf, err := getConfig(`{
+		"http://cmdb.ft.com/systems/cct": [
+			{"content_type": ".*", "collection": "universal-content", "uuid_fields": ["id"]}
+		]
+	}`)
+	assert.NoError(t, err, "It should not return an error")
+	p, err := NewContentBodyParser([]string{"uuid"})
+	assert.NoError(t, err, "It should not return an error")
+
+	var written string
+	nws := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "/"+universalContentCollectionName+"/"+aUUID, req.URL.Path)
+		body, _ := ioutil.ReadAll(req.Body)
+		written = string(body)
+	}))
+	defer nws.Close()
+	w := NewWriter(nws.URL, conf, p, NoRetryPolicy, log)
+
+	body := `{"uuid": "` + aUUID + `", "id": "07ac9fad-6434-47c7-b7c4-34361a048d07", "notes": "do not store"}`
+	msg, err := NewNativeMessage(body, aTimestamp, publishRef, messageTypeContentPublished)
+	assert.NoError(t, err, "It should not return an error by creating a message")
+	msg.AddContentTypeHeader(aContentType)
+	msg.AddOriginSystemIDHeader(cctOriginSystemID)
+
+	// the rule the handler got before a configuration reload changed the rule matching the message
+	rule := config.OriginSystemConfig{Collection: universalContentCollectionName, UUIDFields: []string{"uuid"}, Transforms: []config.Transform{{Remove: "notes"}}}
+	contentUUID, _, _, err := w.WriteToCollection(msg, rule, universalContentCollectionName)
+	assert.NoError(t, err, "It should not return an error")
+	assert.Equal(t, aUUID, contentUUID, "The UUID fields of the given rule should be used")
+	assert.NotContains(t, written, "notes", "The transforms of the given rule should be applied")
+}
+
+func TestWriteMessageToCollectionAppliesTransformsOfMatchingRule(t *testing.T) {
+	log := logger.NewUPPLogger("native_writer_test", "DEBUG")
+	conf, err := getConfig(`{
+		"http://cmdb.ft.com/systems/cct": [
+			{
+				"content_type": ".*",
+				"collection": "universal-content",
+				"transforms": [
+					{"remove": "internal.notes"},
+					{"rename": "headline", "to": "title"},
+					{"set": "source", "value": "cct"},
+					{"copy_header": "X-Schema-Version", "to": "schema.version"}
+				]
+			}
+		]
+	}`)
+	assert.NoError(t, err, "It should not return an error")
+	p, err := NewContentBodyParser([]string{"uuid"})
+	assert.NoError(t, err, "It should not return an error")
+
+	var written string
+	nws := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		written = string(body)
+	}))
+	defer nws.Close()
+	w := NewWriter(nws.URL, conf, p, NoRetryPolicy, log)
+
+	body := `{"uuid": "` + aUUID + `", "headline": "Title", "internal": {"notes": "do not store", "owner": "desk"}}`
+	msg, err := NewNativeMessage(body, aTimestamp, publishRef, messageTypeContentPublished)
+	assert.NoError(t, err, "It should not return an error by creating a message")
+	msg.AddContentTypeHeader(aContentType)
+	msg.AddOriginSystemIDHeader(cctOriginSystemID)
+	msg.SetMessageHeaders(map[string]string{"X-Schema-Version": "3"})
+
+	_, _, _, err = w.WriteToCollection(msg, ruleOf(w, msg), universalContentCollectionName)
+	assert.NoError(t, err, "It should not return an error")
+	assert.JSONEq(t, `{
+		"uuid": "`+aUUID+`",
+		"title": "Title",
+		"internal": {"owner": "desk"},
+		"source": "cct",
+		"schema": {"version": "3"},
+		"lastModified": "`+aTimestamp+`",
+		"publishReference": "`+publishRef+`"
+	}`, written)
+}
+
 func TestWritePartialMessageToCollectionWithSuccess(t *testing.T) {
 	log := logger.NewUPPLogger("native_writer_test", "DEBUG")
 	p := new(ContentBodyParserMock)
@@ -303,11 +469,109 @@ func TestWritePartialMessageToCollectionWithSuccess(t *testing.T) {
 	msg.AddContentTypeHeader(aContentType)
 	assert.NoError(t, err, "It should not return an error by creating a message")
 
-	w := NewWriter(nws.URL, *testCollectionsOriginIdsMap, p, log)
-	contentUUID, _, err := w.WriteToCollection(msg, universalContentCollectionName)
+	w := NewWriter(nws.URL, testCollectionsOriginIdsMap, p, NoRetryPolicy, log)
+	contentUUID, _, _, err := w.WriteToCollection(msg, ruleOf(w, msg), universalContentCollectionName)
+
+	assert.NoError(t, err, "It should not return an error")
+	assert.Equal(t, aUUID, contentUUID)
+	p.AssertExpectations(t)
+}
+
+func TestDeleteMessageFromCollectionWithSuccess(t *testing.T) {
+	log := logger.NewUPPLogger("native_writer_test", "DEBUG")
+	p := new(ContentBodyParserMock)
+	testCollectionsOriginIdsMap, err := getConfig(strCollectionsOriginIdsMap)
+	assert.NoError(t, err, "It should not return an error")
+	deleteBody := map[string]interface{}{
+		"publishReference": publishRef,
+		"lastModified":     aTimestamp,
+		"uuid":             aUUID,
+	}
+	p.On("getUUID", deleteBody).Return(aUUID, nil)
+	nws := setupMockNativeWriterService(t, 200, withoutNativeHashHeader, "DELETE", universalContentCollectionName)
+	defer nws.Close()
+
+	msg, err := NewNativeMessage(`{"uuid":"`+aUUID+`"}`, aTimestamp, publishRef, messageTypeContentDeleted)
+	msg.AddContentTypeHeader(aContentType)
+	assert.NoError(t, err, "It should not return an error by creating a message")
+	assert.True(t, msg.IsDelete(), "It should be a delete message")
+	assert.False(t, msg.IsPartialContent(), "It should not be a partial content message")
+
+	w := NewWriter(nws.URL, testCollectionsOriginIdsMap, p, NoRetryPolicy, log)
+	contentUUID, _, _, err := w.WriteToCollection(msg, ruleOf(w, msg), universalContentCollectionName)
+
+	assert.NoError(t, err, "It should not return an error")
+	assert.Equal(t, aUUID, contentUUID)
+	p.AssertExpectations(t)
+}
+
+func TestDeleteMessageSendsNoBody(t *testing.T) {
+	log := logger.NewUPPLogger("native_writer_test", "DEBUG")
+	p := new(ContentBodyParserMock)
+	testCollectionsOriginIdsMap, err := getConfig(strCollectionsOriginIdsMap)
+	assert.NoError(t, err, "It should not return an error")
+	p.On("getUUID", aContentBody).Return(aUUID, nil)
+
+	nws := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "DELETE", req.Method)
+		body, err := ioutil.ReadAll(req.Body)
+		assert.NoError(t, err)
+		assert.Empty(t, body, "A delete request should not have a body")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer nws.Close()
+
+	msg, err := NewNativeMessage("{}", aTimestamp, publishRef, messageTypeContentPublished)
+	assert.NoError(t, err, "It should not return an error by creating a message")
+	msg.MarkAsDelete()
+
+	w := NewWriter(nws.URL, testCollectionsOriginIdsMap, p, NoRetryPolicy, log)
+	_, updatedContent, _, err := w.WriteToCollection(msg, ruleOf(w, msg), universalContentCollectionName)
+
+	assert.NoError(t, err, "It should not return an error")
+	assert.Empty(t, updatedContent)
+	p.AssertExpectations(t)
+}
+
+func TestDeleteMessageFromCollectionFailBecauseOfNativeRWServiceInternalError(t *testing.T) {
+	log := logger.NewUPPLogger("native_writer_test", "DEBUG")
+	p := new(ContentBodyParserMock)
+	testCollectionsOriginIdsMap, err := getConfig(strCollectionsOriginIdsMap)
+	assert.NoError(t, err, "It should not return an error")
+	p.On("getUUID", aContentBody).Return(aUUID, nil)
+	nws := setupMockNativeWriterService(t, 500, withoutNativeHashHeader, "DELETE", universalContentCollectionName)
+	defer nws.Close()
+
+	msg, err := NewNativeMessage("{}", aTimestamp, publishRef, messageTypeContentDeleted)
+	assert.NoError(t, err, "It should not return an error by creating a message")
+	msg.AddContentTypeHeader(aContentType)
+
+	w := NewWriter(nws.URL, testCollectionsOriginIdsMap, p, NoRetryPolicy, log)
+	_, _, _, err = w.WriteToCollection(msg, ruleOf(w, msg), universalContentCollectionName)
+
+	assert.EqualError(t, err, "Native writer returned non-200 code", "It should return a non-200 HTTP status error")
+	p.AssertExpectations(t)
+}
 
+func TestDeleteMessageAbsentFromCollectionSucceeds(t *testing.T) {
+	log := logger.NewUPPLogger("native_writer_test", "DEBUG")
+	p := new(ContentBodyParserMock)
+	testCollectionsOriginIdsMap, err := getConfig(strCollectionsOriginIdsMap)
 	assert.NoError(t, err, "It should not return an error")
+	p.On("getUUID", aContentBody).Return(aUUID, nil)
+	nws := setupMockNativeWriterService(t, 404, withoutNativeHashHeader, "DELETE", universalContentCollectionName)
+	defer nws.Close()
+
+	msg, err := NewNativeMessage("{}", aTimestamp, publishRef, messageTypeContentDeleted)
+	assert.NoError(t, err, "It should not return an error by creating a message")
+	msg.AddContentTypeHeader(aContentType)
+
+	w := NewWriter(nws.URL, testCollectionsOriginIdsMap, p, testRetryPolicy, log)
+	contentUUID, _, status, err := w.WriteToCollection(msg, ruleOf(w, msg), universalContentCollectionName)
+
+	assert.NoError(t, err, "Deleting content that is not in the native store should succeed")
 	assert.Equal(t, aUUID, contentUUID)
+	assert.Equal(t, http.StatusNotFound, status)
 	p.AssertExpectations(t)
 }
 
@@ -326,8 +590,8 @@ func TestWriteMessageWithHashToCollectionWithSuccess(t *testing.T) {
 	msg.AddHashHeader(aHash)
 	msg.AddContentTypeHeader(aContentType)
 
-	w := NewWriter(nws.URL, *testCollectionsOriginIdsMap, p, log)
-	contentUUID, _, err := w.WriteToCollection(msg, universalContentCollectionName)
+	w := NewWriter(nws.URL, testCollectionsOriginIdsMap, p, NoRetryPolicy, log)
+	contentUUID, _, _, err := w.WriteToCollection(msg, ruleOf(w, msg), universalContentCollectionName)
 
 	assert.NoError(t, err, "It should not return an error")
 	assert.Equal(t, aUUID, contentUUID)
@@ -349,8 +613,8 @@ func TestWriteMessageToCollectionWithContentTypeSuccess(t *testing.T) {
 	msg.AddHashHeader(aHash)
 	msg.AddContentTypeHeader(aContentType)
 
-	w := NewWriter(nws.URL, *testCollectionsOriginIdsMap, p, log)
-	contentUUID, _, err := w.WriteToCollection(msg, universalContentCollectionName)
+	w := NewWriter(nws.URL, testCollectionsOriginIdsMap, p, NoRetryPolicy, log)
+	contentUUID, _, _, err := w.WriteToCollection(msg, ruleOf(w, msg), universalContentCollectionName)
 
 	assert.NoError(t, err, "It should not return an error")
 	assert.Equal(t, aUUID, contentUUID)
@@ -371,10 +635,12 @@ func TestWriteContentBodyToCollectionFailBecauseOfMissingUUID(t *testing.T) {
 	assert.NoError(t, err, "It should not return an error by creating a message")
 	msg.AddHashHeader(aHash)
 
-	w := NewWriter(nws.URL, *testCollectionsOriginIdsMap, p, log)
-	_, _, err = w.WriteToCollection(msg, universalContentCollectionName)
+	w := NewWriter(nws.URL, testCollectionsOriginIdsMap, p, NoRetryPolicy, log)
+	_, _, _, err = w.WriteToCollection(msg, ruleOf(w, msg), universalContentCollectionName)
 
 	assert.EqualError(t, err, "UUID not found", "It should return a  UUID not found error")
+	var uuidErr *UUIDExtractionError
+	assert.True(t, errors.As(err, &uuidErr), "It should return a UUID extraction error")
 	p.AssertExpectations(t)
 }
 
@@ -393,8 +659,8 @@ func TestWriteContentBodyToCollectionFailBecauseOfNativeRWServiceInternalError(t
 	msg.AddHashHeader(aHash)
 	msg.AddContentTypeHeader(aContentType)
 
-	w := NewWriter(nws.URL, *testCollectionsOriginIdsMap, p, log)
-	_, _, err = w.WriteToCollection(msg, universalContentCollectionName)
+	w := NewWriter(nws.URL, testCollectionsOriginIdsMap, p, NoRetryPolicy, log)
+	_, _, _, err = w.WriteToCollection(msg, ruleOf(w, msg), universalContentCollectionName)
 
 	assert.EqualError(t, err, "Native writer returned non-200 code", "It should return a non-200 HTTP status error")
 	p.AssertExpectations(t)
@@ -413,13 +679,181 @@ func TestWriteContentBodyToCollectionFailBecauseOfNativeRWServiceNotAvailable(t
 	msg.AddHashHeader(aHash)
 	msg.AddContentTypeHeader(aContentType)
 
-	w := NewWriter("http://an-address.com", *testCollectionsOriginIdsMap, p, log)
-	_, _, err = w.WriteToCollection(msg, universalContentCollectionName)
+	w := NewWriter("http://an-address.com", testCollectionsOriginIdsMap, p, NoRetryPolicy, log)
+	_, _, _, err = w.WriteToCollection(msg, ruleOf(w, msg), universalContentCollectionName)
 
 	assert.Error(t, err, "It should return an error")
 	p.AssertExpectations(t)
 }
 
+func setupFlakyNativeWriterService(t *testing.T, statuses ...int) (*httptest.Server, *int32) {
+	var calls int32
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		call := atomic.AddInt32(&calls, 1)
+		assert.Equal(t, "/"+universalContentCollectionName+"/"+aUUID, req.URL.Path)
+		body, err := ioutil.ReadAll(req.Body)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, body, "Every attempt should send the full body")
+		if int(call) <= len(statuses) {
+			w.WriteHeader(statuses[call-1])
+		}
+	})), &calls
+}
+
+var testRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     5 * time.Millisecond,
+	Deadline:       time.Second,
+}
+
+func TestWriteMessageToCollectionRetriesOnServerErrors(t *testing.T) {
+	log := logger.NewUPPLogger("native_writer_test", "DEBUG")
+	p := new(ContentBodyParserMock)
+	testCollectionsOriginIdsMap, err := getConfig(strCollectionsOriginIdsMap)
+	assert.NoError(t, err, "It should not return an error")
+	p.On("getUUID", aContentBody).Return(aUUID, nil)
+
+	nws, calls := setupFlakyNativeWriterService(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	defer nws.Close()
+
+	msg, err := NewNativeMessage("{}", aTimestamp, publishRef, messageTypeContentPublished)
+	assert.NoError(t, err, "It should not return an error by creating a message")
+
+	w := NewWriter(nws.URL, testCollectionsOriginIdsMap, p, testRetryPolicy, log)
+	contentUUID, _, _, err := w.WriteToCollection(msg, ruleOf(w, msg), universalContentCollectionName)
+
+	assert.NoError(t, err, "It should succeed on the third attempt")
+	assert.Equal(t, aUUID, contentUUID)
+	assert.Equal(t, int32(3), atomic.LoadInt32(calls), "It should call the native writer three times")
+}
+
+func TestWriteMessageToCollectionGivesUpAfterMaxAttempts(t *testing.T) {
+	log := logger.NewUPPLogger("native_writer_test", "DEBUG")
+	p := new(ContentBodyParserMock)
+	testCollectionsOriginIdsMap, err := getConfig(strCollectionsOriginIdsMap)
+	assert.NoError(t, err, "It should not return an error")
+	p.On("getUUID", aContentBody).Return(aUUID, nil)
+
+	nws, calls := setupFlakyNativeWriterService(t, 500, 500, 500, 500)
+	defer nws.Close()
+
+	msg, err := NewNativeMessage("{}", aTimestamp, publishRef, messageTypeContentPublished)
+	assert.NoError(t, err, "It should not return an error by creating a message")
+
+	w := NewWriter(nws.URL, testCollectionsOriginIdsMap, p, testRetryPolicy, log)
+	_, _, _, err = w.WriteToCollection(msg, ruleOf(w, msg), universalContentCollectionName)
+
+	assert.EqualError(t, err, "Native writer returned non-200 code", "It should return a non-200 HTTP status error")
+	assert.Equal(t, int32(3), atomic.LoadInt32(calls), "It should stop after the maximum number of attempts")
+}
+
+func TestWriteMessageToCollectionDoesNotRetryClientErrors(t *testing.T) {
+	log := logger.NewUPPLogger("native_writer_test", "DEBUG")
+	p := new(ContentBodyParserMock)
+	testCollectionsOriginIdsMap, err := getConfig(strCollectionsOriginIdsMap)
+	assert.NoError(t, err, "It should not return an error")
+	p.On("getUUID", aContentBody).Return(aUUID, nil)
+
+	nws, calls := setupFlakyNativeWriterService(t, http.StatusBadRequest)
+	defer nws.Close()
+
+	msg, err := NewNativeMessage("{}", aTimestamp, publishRef, messageTypeContentPublished)
+	assert.NoError(t, err, "It should not return an error by creating a message")
+
+	w := NewWriter(nws.URL, testCollectionsOriginIdsMap, p, testRetryPolicy, log)
+	_, _, _, err = w.WriteToCollection(msg, ruleOf(w, msg), universalContentCollectionName)
+
+	assert.Error(t, err, "It should return an error")
+	assert.Equal(t, int32(1), atomic.LoadInt32(calls), "It should not retry a 4xx response")
+}
+
+func TestWriteMessageToCollectionReportsConflictAsStale(t *testing.T) {
+	log := logger.NewUPPLogger("native_writer_test", "DEBUG")
+	p := new(ContentBodyParserMock)
+	testCollectionsOriginIdsMap, err := getConfig(strCollectionsOriginIdsMap)
+	assert.NoError(t, err, "It should not return an error")
+	p.On("getUUID", aContentBody).Return(aUUID, nil)
+
+	nws, calls := setupFlakyNativeWriterService(t, http.StatusConflict)
+	defer nws.Close()
+
+	msg, err := NewNativeMessage("{}", aTimestamp, publishRef, messageTypeContentPublished)
+	assert.NoError(t, err, "It should not return an error by creating a message")
+
+	w := NewWriter(nws.URL, testCollectionsOriginIdsMap, p, testRetryPolicy, log)
+	_, _, _, err = w.WriteToCollection(msg, ruleOf(w, msg), universalContentCollectionName)
+
+	assert.ErrorIs(t, err, ErrStaleContent, "It should report the content as stale")
+	assert.Equal(t, int32(1), atomic.LoadInt32(calls), "It should not retry a 409 response")
+}
+
+func TestWriteMessageToCollectionStopsRetryingAfterDeadline(t *testing.T) {
+	log := logger.NewUPPLogger("native_writer_test", "DEBUG")
+	p := new(ContentBodyParserMock)
+	testCollectionsOriginIdsMap, err := getConfig(strCollectionsOriginIdsMap)
+	assert.NoError(t, err, "It should not return an error")
+	p.On("getUUID", aContentBody).Return(aUUID, nil)
+
+	nws, calls := setupFlakyNativeWriterService(t, 500, 500, 500)
+	defer nws.Close()
+
+	msg, err := NewNativeMessage("{}", aTimestamp, publishRef, messageTypeContentPublished)
+	assert.NoError(t, err, "It should not return an error by creating a message")
+
+	policy := RetryPolicy{
+		MaxAttempts:    10,
+		InitialBackoff: time.Second,
+		MaxBackoff:     time.Second,
+		Deadline:       100 * time.Millisecond,
+	}
+	w := NewWriter(nws.URL, testCollectionsOriginIdsMap, p, policy, log)
+	_, _, _, err = w.WriteToCollection(msg, ruleOf(w, msg), universalContentCollectionName)
+
+	assert.Error(t, err, "It should return an error")
+	assert.Equal(t, int32(1), atomic.LoadInt32(calls), "It should not wait past the retry deadline")
+}
+
+func TestWriteMessageToCollectionCancelsRequestsAfterDeadline(t *testing.T) {
+	log := logger.NewUPPLogger("native_writer_test", "DEBUG")
+	p := new(ContentBodyParserMock)
+	testCollectionsOriginIdsMap, err := getConfig(strCollectionsOriginIdsMap)
+	assert.NoError(t, err, "It should not return an error")
+	p.On("getUUID", aContentBody).Return(aUUID, nil)
+
+	released := make(chan struct{})
+	nws := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-released:
+		}
+	}))
+	defer nws.Close()
+	defer close(released)
+
+	msg, err := NewNativeMessage("{}", aTimestamp, publishRef, messageTypeContentPublished)
+	assert.NoError(t, err, "It should not return an error by creating a message")
+
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond, Deadline: 100 * time.Millisecond}
+	w := NewWriter(nws.URL, testCollectionsOriginIdsMap, p, policy, log)
+	start := time.Now()
+	_, _, _, err = w.WriteToCollection(msg, ruleOf(w, msg), universalContentCollectionName)
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "It should cancel the request at the retry deadline")
+	assert.Less(t, time.Since(start), time.Second, "It should not wait for the native writer past the retry deadline")
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}
+
+	for attempt, max := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 300 * time.Millisecond, 10: 300 * time.Millisecond} {
+		wait := policy.Backoff(attempt)
+		assert.True(t, wait >= max/2 && wait <= max, "Backoff for attempt %d should be between %v and %v, got %v", attempt, max/2, max, wait)
+	}
+	assert.Equal(t, time.Duration(0), NoRetryPolicy.Backoff(1))
+	assert.Equal(t, 1, NoRetryPolicy.Attempts())
+}
+
 func TestConnectivityCheckSuccess(t *testing.T) {
 	log := logger.NewUPPLogger("native_writer_test", "DEBUG")
 	p := new(ContentBodyParserMock)
@@ -428,7 +862,7 @@ func TestConnectivityCheckSuccess(t *testing.T) {
 
 	nws := setupMockNativeWriterGTG(t, 200)
 
-	w := NewWriter(nws.URL, *testCollectionsOriginIdsMap, p, log)
+	w := NewWriter(nws.URL, testCollectionsOriginIdsMap, p, NoRetryPolicy, log)
 	msg, err := w.ConnectivityCheck()
 
 	assert.NoError(t, err, "It should not return an error")
@@ -443,7 +877,7 @@ func TestConnectivityCheckSuccessWithoutHostHeader(t *testing.T) {
 
 	nws := setupMockNativeWriterGTG(t, 200)
 
-	w := NewWriter(nws.URL, *testCollectionsOriginIdsMap, p, log)
+	w := NewWriter(nws.URL, testCollectionsOriginIdsMap, p, NoRetryPolicy, log)
 	msg, err := w.ConnectivityCheck()
 
 	assert.NoError(t, err, "It should not return an error")
@@ -458,7 +892,7 @@ func TestConnectivityCheckFailNotGTG(t *testing.T) {
 
 	nws := setupMockNativeWriterGTG(t, 503)
 
-	w := NewWriter(nws.URL, *testCollectionsOriginIdsMap, p, log)
+	w := NewWriter(nws.URL, testCollectionsOriginIdsMap, p, NoRetryPolicy, log)
 	msg, err := w.ConnectivityCheck()
 
 	assert.EqualError(t, err, "GTG HTTP status code is 503", "It should return an error")
@@ -471,7 +905,7 @@ func TestConnectivityCheckFailNativeRWServiceNotAvailable(t *testing.T) {
 	testCollectionsOriginIdsMap, err := getConfig(strCollectionsOriginIdsMap)
 	assert.NoError(t, err, "It should not return an error")
 
-	w := NewWriter("http://an-address.com", *testCollectionsOriginIdsMap, p, log)
+	w := NewWriter("http://an-address.com", testCollectionsOriginIdsMap, p, NoRetryPolicy, log)
 	msg, err := w.ConnectivityCheck()
 
 	assert.Error(t, err, "It should return an error")
@@ -484,7 +918,7 @@ func TestConnectivityCheckFailToBuildRequest(t *testing.T) {
 	testCollectionsOriginIdsMap, err := getConfig(strCollectionsOriginIdsMap)
 	assert.NoError(t, err, "It should not return an error")
 
-	w := NewWriter("http://foo.com  and some spaces", *testCollectionsOriginIdsMap, p, log)
+	w := NewWriter("http://foo.com  and some spaces", testCollectionsOriginIdsMap, p, NoRetryPolicy, log)
 	msg, err := w.ConnectivityCheck()
 
 	assert.Error(t, err, "It should return an error")
@@ -495,9 +929,14 @@ type ContentBodyParserMock struct {
 	mock.Mock
 }
 
-func (p *ContentBodyParserMock) getUUID(body map[string]interface{}) (string, error) {
+func (p *ContentBodyParserMock) withPaths(uuidJSONPaths []string) (ContentBodyParser, error) {
+	args := p.Called(uuidJSONPaths)
+	return args.Get(0).(ContentBodyParser), args.Error(1)
+}
+
+func (p *ContentBodyParserMock) getUUID(body map[string]interface{}) (string, uuidMatch, error) {
 	args := p.Called(body)
-	return args.String(0), args.Error(1)
+	return args.String(0), uuidMatch{}, args.Error(1)
 }
 
 func TestBuildNativeMessageSuccess(t *testing.T) {
@@ -516,3 +955,47 @@ func TestBuildNativeMessageFailure(t *testing.T) {
 	_, err := NewNativeMessage("__INVALID_BODY__", aTimestamp, publishRef, messageTypeContentPublished)
 	assert.EqualError(t, err, "invalid character '_' looking for beginning of value", "It should return an error in creating a new message")
 }
+
+func TestNativeMessageHasBodyMarker(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		marker string
+		want   bool
+	}{
+		{"no marker", `{"deleted":true}`, "", false},
+		{"boolean true", `{"deleted":true}`, "deleted", true},
+		{"boolean false", `{"deleted":false}`, "deleted", false},
+		{"not a boolean", `{"deleted":"true"}`, "deleted", false},
+		{"missing field", `{"foo":"bar"}`, "deleted", false},
+		{"nested boolean", `{"meta":{"deleted":true}}`, "meta.deleted", true},
+		{"value match", `{"type":"ContentDeletion"}`, "type=ContentDeletion", true},
+		{"value mismatch", `{"type":"Article"}`, "type=ContentDeletion", false},
+		{"boolean value match", `{"deleted":true}`, "deleted=true", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := NewNativeMessage(tt.body, aTimestamp, publishRef, messageTypeContentPublished)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, msg.HasBodyMarker(tt.marker))
+		})
+	}
+}
+
+func TestNativeMessageWriteMethod(t *testing.T) {
+	tests := []struct {
+		messageType string
+		want        string
+	}{
+		{messageTypeContentPublished, "POST"},
+		{messageTypePartialContentPublished, "PATCH"},
+		{messageTypeContentDeleted, "DELETE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.messageType, func(t *testing.T) {
+			msg, err := NewNativeMessage("{}", aTimestamp, publishRef, tt.messageType)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, msg.WriteMethod())
+		})
+	}
+}
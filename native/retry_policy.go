@@ -0,0 +1,51 @@
+package native
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy describes how calls to the native writer, or to the producer forwarding messages, are retried on transient failures
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Deadline       time.Duration
+}
+
+// NoRetryPolicy performs a single attempt for each call to the native writer
+var NoRetryPolicy = RetryPolicy{MaxAttempts: 1}
+
+// Attempts returns the maximum number of attempts of each call, at least 1
+func (p RetryPolicy) Attempts() int {
+	if p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+// Backoff returns the time to wait before the given retry attempt (starting at 1),
+// growing exponentially up to MaxBackoff with a random jitter of up to half the delay.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	if p.InitialBackoff <= 0 {
+		return 0
+	}
+	delay := p.InitialBackoff
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if p.MaxBackoff > 0 && delay >= p.MaxBackoff {
+			delay = p.MaxBackoff
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+func isRetryableStatusCode(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
@@ -0,0 +1,105 @@
+package native
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	uuidParser "github.com/google/uuid"
+)
+
+// uuidDerivation turns the identifier found at a UUID path, e.g. a video ID or a URL, into a UUID
+type uuidDerivation struct {
+	rule   string
+	derive func(id string) (string, bool)
+}
+
+var uuidNamespaces = map[string]uuidParser.UUID{
+	"dns":  uuidParser.NameSpaceDNS,
+	"url":  uuidParser.NameSpaceURL,
+	"oid":  uuidParser.NameSpaceOID,
+	"x500": uuidParser.NameSpaceX500,
+}
+
+var derivationRuleNames = []string{"v3", "v5", "regex"}
+
+// parseUUIDDerivationSpec splits a path=rule derivation at the first "=" followed by a rule name,
+// so that both the path and the rule can contain "="
+func parseUUIDDerivationSpec(spec string) (string, *uuidDerivation, error) {
+	split := -1
+	for _, name := range derivationRuleNames {
+		if i := strings.Index(spec, "="+name+":"); i > 0 && (split == -1 || i < split) {
+			split = i
+		}
+	}
+	if split == -1 {
+		return "", nil, fmt.Errorf("invalid UUID derivation %q: expected path=v3:namespace, path=v5:namespace or path=regex:expression", spec)
+	}
+	derivation, err := parseUUIDDerivation(spec[split+1:])
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid UUID derivation %q: %w", spec, err)
+	}
+	return spec[:split], derivation, nil
+}
+
+// parseUUIDDerivation parses a derivation rule:
+//   - v3:namespace and v5:namespace generate a name-based UUID from the identifier, in a namespace
+//     given as a UUID or as one of dns, url, oid and x500
+//   - regex:expression extracts the UUID from the identifier, as the first group of the expression if it has one
+func parseUUIDDerivation(rule string) (*uuidDerivation, error) {
+	name, arg, _ := strings.Cut(rule, ":")
+	switch name {
+	case "v3", "v5":
+		namespace, found := uuidNamespaces[strings.ToLower(arg)]
+		if !found {
+			var err error
+			if namespace, err = uuidParser.Parse(arg); err != nil {
+				return nil, fmt.Errorf("invalid namespace %q: %w", arg, err)
+			}
+		}
+		generate := uuidParser.NewSHA1
+		if name == "v3" {
+			generate = uuidParser.NewMD5
+		}
+		return &uuidDerivation{rule, func(id string) (string, bool) {
+			return generate(namespace, []byte(id)).String(), true
+		}}, nil
+	case "regex":
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expression: %w", err)
+		}
+		return &uuidDerivation{rule, func(id string) (string, bool) {
+			match := re.FindStringSubmatch(id)
+			if match == nil {
+				return "", false
+			}
+			uuid := match[0]
+			if len(match) > 1 {
+				uuid = match[1]
+			}
+			_, err := uuidParser.Parse(uuid)
+			return uuid, err == nil
+		}}, nil
+	}
+	return nil, errors.New("unknown rule " + name)
+}
+
+// apply derives the UUID from the value found at the path, or from the first string if it is a list
+func (d *uuidDerivation) apply(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return "", false
+		}
+		return d.derive(v)
+	case []interface{}:
+		for _, item := range v {
+			if uuid, found := d.apply(item); found {
+				return uuid, true
+			}
+		}
+	}
+	return "", false
+}
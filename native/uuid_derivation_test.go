@@ -0,0 +1,108 @@
+package native
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeriveUUID(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		paths      []string
+		derivation string
+		wantUUID   string
+		wantMatch  uuidMatch
+	}{
+		{
+			"v5 with named namespace",
+			`{"videoId": "video-12345"}`,
+			[]string{"uuid", "videoId"},
+			"videoId=v5:dns",
+			"ce76f1cd-57a2-563c-9a2c-d8a1a76921ec",
+			uuidMatch{"videoId", "v5:dns"},
+		},
+		{
+			"v3 with UUID namespace",
+			`{"video": {"id": "video-12345"}}`,
+			[]string{"video.id"},
+			"video.id=v3:6ba7b811-9dad-11d1-80b4-00c04fd430c8",
+			"39cc0375-e7d2-3fcc-8111-879fab8e28a5",
+			uuidMatch{"video.id", "v3:6ba7b811-9dad-11d1-80b4-00c04fd430c8"},
+		},
+		{
+			"regex with group",
+			`{"url": "https://www.ft.com/content/07ac9fad-6434-47c7-b7c4-34361a048d07?page=2"}`,
+			[]string{"url"},
+			`url=regex:/content/([0-9a-f-]{36})`,
+			"07ac9fad-6434-47c7-b7c4-34361a048d07",
+			uuidMatch{"url", "regex:/content/([0-9a-f-]{36})"},
+		},
+		{
+			"regex without group",
+			`{"url": "http://api.ft.com/things/07ac9fad-6434-47c7-b7c4-34361a048d07"}`,
+			[]string{"url"},
+			`url=regex:[0-9a-f-]{36}$`,
+			"07ac9fad-6434-47c7-b7c4-34361a048d07",
+			uuidMatch{"url", "regex:[0-9a-f-]{36}$"},
+		},
+		{
+			"path with equals sign",
+			`{"ids": [{"type": "video", "id": "video-12345"}]}`,
+			[]string{"ids[?type=='video'].id"},
+			"ids[?type=='video'].id=v5:dns",
+			"ce76f1cd-57a2-563c-9a2c-d8a1a76921ec",
+			uuidMatch{"ids[?type=='video'].id", "v5:dns"},
+		},
+		{
+			"UUID found before the derived path",
+			`{"uuid": "07ac9fad-6434-47c7-b7c4-34361a048d07", "videoId": "video-12345"}`,
+			[]string{"uuid", "videoId"},
+			"videoId=v5:dns",
+			"07ac9fad-6434-47c7-b7c4-34361a048d07",
+			uuidMatch{path: "uuid"},
+		},
+		{
+			"regex not matching falls back to the next path",
+			`{"url": "https://www.ft.com/video", "uuid": "07ac9fad-6434-47c7-b7c4-34361a048d07"}`,
+			[]string{"url", "uuid"},
+			`url=regex:/content/([0-9a-f-]{36})`,
+			"07ac9fad-6434-47c7-b7c4-34361a048d07",
+			uuidMatch{path: "uuid"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewContentBodyParser(tt.paths, tt.derivation)
+			assert.NoError(t, err)
+			body := make(map[string]interface{})
+			assert.NoError(t, json.Unmarshal([]byte(tt.body), &body))
+
+			uuid, match, err := p.getUUID(body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantUUID, uuid)
+			assert.Equal(t, tt.wantMatch, match)
+		})
+	}
+}
+
+func TestInvalidUUIDDerivations(t *testing.T) {
+	tests := []struct {
+		derivation string
+		wantErr    string
+	}{
+		{"videoId", `invalid UUID derivation "videoId": expected path=v3:namespace, path=v5:namespace or path=regex:expression`},
+		{"videoId=v4:dns", `invalid UUID derivation "videoId=v4:dns": expected path=v3:namespace, path=v5:namespace or path=regex:expression`},
+		{"videoId=v5:unknown", `invalid UUID derivation "videoId=v5:unknown": invalid namespace "unknown": invalid UUID length: 7`},
+		{"videoId=regex:(", "invalid UUID derivation \"videoId=regex:(\": invalid expression: error parsing regexp: missing closing ): `(`"},
+		{"url=v5:dns", `UUID derivation "url=v5:dns": "url" is not one of the UUID paths`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.derivation, func(t *testing.T) {
+			_, err := NewContentBodyParser([]string{"uuid", "videoId"}, tt.derivation)
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package queue
+
+import (
+	"regexp"
+	"strconv"
+
+	"github.com/Financial-Times/kafka-client-go/v4"
+)
+
+// Stages of the message handling at which a message can be dead-lettered
+const (
+	stageUnmarshal      = "unmarshal"
+	stageTimestamp      = "timestamp"
+	stageUUIDExtraction = "uuid-extraction"
+	stageSchema         = "schema"
+	stageStale          = "stale"
+	stageWrite          = "write"
+	stageForward        = "forward"
+)
+
+const (
+	deadLetterStageHeader       = "X-Dead-Letter-Stage"
+	deadLetterErrorHeader       = "X-Dead-Letter-Error"
+	deadLetterSourceTopicHeader = "X-Dead-Letter-Source-Topic"
+	deadLetterAttemptHeader     = "X-Dead-Letter-Attempt-Count"
+)
+
+// headerUnsafeChars matches the characters that would be dropped when an FT message header is parsed back
+var headerUnsafeChars = regexp.MustCompile(`[^\w\-:/.+;= ]`)
+
+// deadLetterMsg builds the message sent to the dead-letter topic: the original message
+// with additional headers describing why and where its processing failed.
+// The attempt count is increased every time a replayed message is dead-lettered again.
+func deadLetterMsg(msg kafka.FTMessage, stage string, cause error) kafka.FTMessage {
+	headers := make(map[string]string, len(msg.Headers)+4)
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+
+	attempt, err := strconv.Atoi(msg.Headers[deadLetterAttemptHeader])
+	if err != nil {
+		attempt = 0
+	}
+
+	headers[deadLetterStageHeader] = stage
+	headers[deadLetterErrorHeader] = headerUnsafeChars.ReplaceAllString(cause.Error(), " ")
+	headers[deadLetterSourceTopicHeader] = msg.Topic
+	headers[deadLetterAttemptHeader] = strconv.Itoa(attempt + 1)
+
+	return kafka.FTMessage{
+		Headers: headers,
+		Body:    msg.Body,
+	}
+}
@@ -0,0 +1,171 @@
+package queue
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// HashStore keeps the native hash of the content last written for each collection and content UUID
+type HashStore interface {
+	Hash(collection string, contentUUID string) (string, bool)
+	Store(collection string, contentUUID string, hash string) error
+	Forget(collection string, contentUUID string) error
+}
+
+// lruHashStore is a HashStore bounded in size, evicting the least recently used hashes first
+type lruHashStore struct {
+	mu    sync.Mutex
+	cache *lru[string]
+}
+
+// NewLRUHashStore returns an in-memory HashStore keeping at most size hashes
+func NewLRUHashStore(size int) HashStore {
+	return newLRUHashStore(size)
+}
+
+func newLRUHashStore(size int) *lruHashStore {
+	return &lruHashStore{cache: newLRU[string](size)}
+}
+
+func hashKey(collection string, contentUUID string) string {
+	return collection + "/" + contentUUID
+}
+
+func (s *lruHashStore) Hash(collection string, contentUUID string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cache.get(hashKey(collection, contentUUID))
+}
+
+func (s *lruHashStore) Store(collection string, contentUUID string, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cache.put(hashKey(collection, contentUUID), hash)
+	return nil
+}
+
+func (s *lruHashStore) Forget(collection string, contentUUID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cache.remove(hashKey(collection, contentUUID))
+	return nil
+}
+
+// FileHashStore is a bounded HashStore that survives restarts, by journaling its changes to a local file.
+// The journal is compacted to the current hashes when it grows to twice the size of the store.
+type FileHashStore struct {
+	*lruHashStore
+	path    string
+	journal *os.File
+	lines   int
+}
+
+// NewFileHashStore returns a HashStore keeping at most size hashes, loaded from and persisted to the given file
+func NewFileHashStore(path string, size int) (*FileHashStore, error) {
+	s := &FileHashStore{lruHashStore: newLRUHashStore(size), path: path}
+	if err := s.load(); err != nil {
+		return nil, fmt.Errorf("loading hash store: %w", err)
+	}
+	if err := s.compact(); err != nil {
+		return nil, fmt.Errorf("compacting hash store: %w", err)
+	}
+	return s, nil
+}
+
+func (s *FileHashStore) Store(collection string, contentUUID string, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := hashKey(collection, contentUUID)
+	s.cache.put(key, hash)
+	return s.append(key, hash)
+}
+
+func (s *FileHashStore) Forget(collection string, contentUUID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := hashKey(collection, contentUUID)
+	if !s.cache.remove(key) {
+		return nil
+	}
+	return s.append(key, "")
+}
+
+// Close closes the journal file
+func (s *FileHashStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.journal.Close()
+}
+
+// load replays the journal, where every line is a key and a hash separated by a tab, an empty hash removing the key
+func (s *FileHashStore) load() error {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, hash, found := strings.Cut(scanner.Text(), "\t")
+		if !found {
+			continue
+		}
+		if hash == "" {
+			s.cache.remove(key)
+		} else {
+			s.cache.put(key, hash)
+		}
+	}
+	return scanner.Err()
+}
+
+func (s *FileHashStore) append(key string, hash string) error {
+	if s.lines >= 2*s.cache.size {
+		if err := s.compact(); err != nil {
+			return fmt.Errorf("compacting hash store: %w", err)
+		}
+		return nil
+	}
+	if _, err := fmt.Fprintf(s.journal, "%s\t%s\n", key, hash); err != nil {
+		return fmt.Errorf("writing hash store: %w", err)
+	}
+	s.lines++
+	return nil
+}
+
+// compact rewrites the journal with the current hashes only, oldest first, and replaces the previous journal
+func (s *FileHashStore) compact() error {
+	tmp := s.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	s.cache.oldestFirst(func(key string, hash string) {
+		fmt.Fprintf(w, "%s\t%s\n", key, hash)
+	})
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+
+	if s.journal != nil {
+		s.journal.Close()
+	}
+	s.journal, err = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	s.lines = s.cache.len()
+	return err
+}
@@ -0,0 +1,80 @@
+package queue
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLRUHashStore(t *testing.T) {
+	s := NewLRUHashStore(2)
+
+	require.NoError(t, s.Store("universal-content", "uuid-1", "hash-1"))
+	require.NoError(t, s.Store("universal-content", "uuid-2", "hash-2"))
+	hash, found := s.Hash("universal-content", "uuid-1")
+	assert.True(t, found)
+	assert.Equal(t, "hash-1", hash)
+
+	_, found = s.Hash("pages", "uuid-1")
+	assert.False(t, found, "Hashes should be stored per collection")
+
+	require.NoError(t, s.Store("universal-content", "uuid-3", "hash-3"))
+	_, found = s.Hash("universal-content", "uuid-2")
+	assert.False(t, found, "The least recently used hash should be evicted")
+	_, found = s.Hash("universal-content", "uuid-1")
+	assert.True(t, found)
+
+	require.NoError(t, s.Forget("universal-content", "uuid-1"))
+	_, found = s.Hash("universal-content", "uuid-1")
+	assert.False(t, found)
+}
+
+func TestFileHashStoreSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hashes")
+
+	s, err := NewFileHashStore(path, 10)
+	require.NoError(t, err)
+	require.NoError(t, s.Store("universal-content", "uuid-1", "hash-1"))
+	require.NoError(t, s.Store("universal-content", "uuid-2", "hash-2"))
+	require.NoError(t, s.Store("universal-content", "uuid-1", "hash-3"))
+	require.NoError(t, s.Forget("universal-content", "uuid-2"))
+	require.NoError(t, s.Close())
+
+	s, err = NewFileHashStore(path, 10)
+	require.NoError(t, err)
+	defer s.Close()
+
+	hash, found := s.Hash("universal-content", "uuid-1")
+	assert.True(t, found)
+	assert.Equal(t, "hash-3", hash)
+	_, found = s.Hash("universal-content", "uuid-2")
+	assert.False(t, found)
+}
+
+func TestFileHashStoreCompactsJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hashes")
+	s, err := NewFileHashStore(path, 2)
+	require.NoError(t, err)
+	defer s.Close()
+
+	for _, hash := range []string{"hash-1", "hash-2", "hash-3", "hash-4", "hash-5", "hash-6"} {
+		require.NoError(t, s.Store("universal-content", "uuid-1", hash))
+	}
+	require.NoError(t, s.Store("universal-content", "uuid-2", "hash-7"))
+
+	journal, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(strings.Split(strings.TrimSpace(string(journal)), "\n")), 4)
+
+	s2, err := NewFileHashStore(path, 2)
+	require.NoError(t, err)
+	defer s2.Close()
+	hash, _ := s2.Hash("universal-content", "uuid-1")
+	assert.Equal(t, "hash-6", hash)
+	hash, _ = s2.Hash("universal-content", "uuid-2")
+	assert.Equal(t, "hash-7", hash)
+}
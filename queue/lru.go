@@ -0,0 +1,65 @@
+package queue
+
+import "container/list"
+
+// lru is a map bounded in size, evicting the least recently used entries first.
+// It is not safe for concurrent use.
+type lru[V any] struct {
+	size    int
+	entries *list.List
+	index   map[string]*list.Element
+}
+
+type lruEntry[V any] struct {
+	key   string
+	value V
+}
+
+func newLRU[V any](size int) *lru[V] {
+	return &lru[V]{size: size, entries: list.New(), index: make(map[string]*list.Element)}
+}
+
+func (c *lru[V]) get(key string) (V, bool) {
+	e, found := c.index[key]
+	if !found {
+		var zero V
+		return zero, false
+	}
+	c.entries.MoveToFront(e)
+	return e.Value.(*lruEntry[V]).value, true
+}
+
+func (c *lru[V]) put(key string, value V) {
+	if e, found := c.index[key]; found {
+		e.Value.(*lruEntry[V]).value = value
+		c.entries.MoveToFront(e)
+		return
+	}
+	c.index[key] = c.entries.PushFront(&lruEntry[V]{key, value})
+	if c.entries.Len() > c.size {
+		oldest := c.entries.Back()
+		c.entries.Remove(oldest)
+		delete(c.index, oldest.Value.(*lruEntry[V]).key)
+	}
+}
+
+func (c *lru[V]) remove(key string) bool {
+	e, found := c.index[key]
+	if found {
+		c.entries.Remove(e)
+		delete(c.index, key)
+	}
+	return found
+}
+
+func (c *lru[V]) len() int {
+	return c.entries.Len()
+}
+
+// oldestFirst calls f for every entry, from the least to the most recently used
+func (c *lru[V]) oldestFirst(f func(key string, value V)) {
+	for e := c.entries.Back(); e != nil; e = e.Prev() {
+		entry := e.Value.(*lruEntry[V])
+		f(entry.key, entry.value)
+	}
+}
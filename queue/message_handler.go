@@ -1,20 +1,41 @@
 package queue
 
 import (
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/Financial-Times/go-logger/v2"
 	"github.com/Financial-Times/kafka-client-go/v4"
+	"github.com/Financial-Times/native-ingester/config"
+	"github.com/Financial-Times/native-ingester/metrics"
 	"github.com/Financial-Times/native-ingester/native"
 )
 
 // MessageHandler handles messages consumed from a queue
 type MessageHandler struct {
-	writer      native.Writer
-	producer    kafkaProducer
-	forwards    bool
-	contentType string
-	logger      *logger.UPPLogger
+	writer             native.Writer
+	producer           kafkaProducer
+	forwards           bool
+	topicProducers     map[string]kafkaProducer
+	forwardBody        string
+	forwardRetry       native.RetryPolicy
+	blocksForward      bool
+	stop               chan struct{}
+	stopOnce           sync.Once
+	deadLetterProducer kafkaProducer
+	deadLetters        bool
+	deleteBodyMarker   string
+	dryRun             bool
+	recorder           messageRecorder
+	hashStore          HashStore
+	forwardsUnchanged  bool
+	revisionGuard      *RevisionGuard
+	deadLettersStale   bool
+	contentType        string
+	appName            string
+	logger             *logger.UPPLogger
 }
 
 type kafkaProducer interface {
@@ -22,15 +43,101 @@ type kafkaProducer interface {
 	Close() error
 }
 
+type messageRecorder interface {
+	Record(msg kafka.FTMessage) error
+}
+
 // NewMessageHandler returns a new instance of MessageHandler
 func NewMessageHandler(w native.Writer, contentType string, logger *logger.UPPLogger) *MessageHandler {
-	return &MessageHandler{writer: w, contentType: contentType, logger: logger}
+	return &MessageHandler{writer: w, contentType: contentType, stop: make(chan struct{}), logger: logger}
+}
+
+// Result describes the outcome of handling a message
+type Result struct {
+	// Collection is the main collection of the message
+	Collection string
+	// Writes reports the outcome of the write to each collection of the message, the main one first
+	Writes      []CollectionWrite
+	ContentUUID string
+	Forwarded   bool
+	Skipped     bool
+	Unchanged   bool
+	DryRun      bool
+	FailedStage string
+	Err         error
+}
+
+// CollectionWrite describes the outcome of writing a message to one of its collections
+type CollectionWrite struct {
+	Collection  string
+	ContentUUID string
+	// Status is the status of the native writer response, or 0 if it was not called or could not be reached
+	Status    int
+	Unchanged bool
+	Err       error
+}
+
+// writeErrors returns the errors of the failed writes, prefixed with their collection when the message has several
+func writeErrors(writes []CollectionWrite) error {
+	var errs []error
+	for _, w := range writes {
+		switch {
+		case w.Err == nil:
+		case len(writes) == 1:
+			errs = append(errs, w.Err)
+		default:
+			errs = append(errs, fmt.Errorf("%s: %w", w.Collection, w.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// unchanged returns true if the message was not written to any of its collections because its Native-Hash did not change
+func (r Result) unchanged() bool {
+	for _, w := range r.Writes {
+		if !w.Unchanged {
+			return false
+		}
+	}
+	return len(r.Writes) > 0
+}
+
+// InvalidMessage returns true if the message failed because of its own content rather than a downstream service
+func (r Result) InvalidMessage() bool {
+	return r.FailedStage == stageUnmarshal || r.FailedStage == stageTimestamp || r.FailedStage == stageUUIDExtraction || r.FailedStage == stageSchema
+}
+
+// Stale returns true if the message was rejected because newer content was already written
+func (r Result) Stale() bool {
+	return r.FailedStage == stageStale
 }
 
 // HandleMessage implements the strategy for handling message from a queue
 func (mh *MessageHandler) HandleMessage(msg kafka.FTMessage) {
+	if mh.recorder != nil {
+		if err := mh.recorder.Record(msg); err != nil {
+			pubEvent := publicationEvent{msg}
+			mh.logger.WithTransactionID(pubEvent.transactionID()).WithError(err).Error("Failed to record consumed message")
+		}
+	}
+	mh.processMessage(msg, mh.blocksForward)
+}
+
+// ProcessMessage handles a message like HandleMessage and reports what happened to it.
+// A message that cannot be forwarded fails after the attempts of the forward retry policy, even if HandleMessage would block on it.
+func (mh *MessageHandler) ProcessMessage(msg kafka.FTMessage) Result {
+	return mh.processMessage(msg, false)
+}
+
+func (mh *MessageHandler) processMessage(msg kafka.FTMessage, blockForward bool) (result Result) {
 	pubEvent := publicationEvent{msg}
 
+	start := time.Now()
+	defer func() {
+		metrics.MessagesConsumed.WithLabelValues(pubEvent.originSystemID(), result.Collection, pubEvent.messageType()).Inc()
+		metrics.HandlingDuration.WithLabelValues(pubEvent.originSystemID(), result.Collection, pubEvent.messageType()).Observe(time.Since(start).Seconds())
+	}()
+
 	mh.logger.WithTransactionID(pubEvent.transactionID()).WithField("Content-Type", pubEvent.contentType()).Infof("Handling new message with headers: %v", pubEvent.Headers)
 
 	logMonitoringEvent := mh.logger.WithMonitoringEvent("Ingest", pubEvent.transactionID(), mh.contentType)
@@ -40,45 +147,229 @@ func (mh *MessageHandler) HandleMessage(msg kafka.FTMessage) {
 		logMonitoringEvent.
 			WithError(err).
 			Error("Error unmarshalling content body from publication event. Ignoring message.")
-		return
+		stage := stageUnmarshal
+		if errors.Is(err, errMissingTimestamp) {
+			stage = stageTimestamp
+		}
+		return mh.fail(msg, result, stage, err)
 	}
 
-	collection, err := mh.writer.GetCollection(pubEvent.originSystemID(), writerMsg.ContentType(), writerMsg.Publication())
+	if writerMsg.HasBodyMarker(mh.deleteBodyMarker) {
+		mh.logger.WithTransactionID(pubEvent.transactionID()).Infof("Message body matches delete marker %q, treating it as a delete event", mh.deleteBodyMarker)
+		writerMsg.MarkAsDelete()
+		pubEvent.setMessageType(writerMsg.MessageType())
+	}
+
+	rule, err := mh.writer.GetRule(writerMsg)
 	if err != nil {
 		logMonitoringEvent.
 			WithValidFlag(false).
 			Warn(fmt.Sprintf("Skipping content because of not whitelisted combination (Origin-System-Id, Content-Type): (%s, %s)", pubEvent.originSystemID(), writerMsg.ContentType()))
-		return
+		metrics.MessagesSkipped.WithLabelValues(pubEvent.originSystemID(), result.Collection, pubEvent.messageType()).Inc()
+		result.Skipped = true
+		result.Err = err
+		return result
 	}
+	result.Collection = rule.Collection
 
-	contentUUID, updatedContent, writerErr := mh.writer.WriteToCollection(writerMsg, collection)
-	if writerErr != nil {
+	// the content UUID is found with the uuid_fields of the rule at every step, as the native writer does
+	parser, err := mh.writer.ParserFor(rule)
+	if err != nil {
+		logMonitoringEvent.
+			WithError(err).
+			Error("Error compiling the UUID fields of the matching config rule. Ignoring message.")
+		return mh.fail(msg, result, stageUUIDExtraction, &native.UUIDExtractionError{Err: err})
+	}
+
+	if err := mh.validateBody(writerMsg, pubEvent, rule); err != nil {
+		logMonitoringEvent.
+			WithError(err).
+			Error("Native content does not match the schema of its config rule. Ignoring message.")
+		return mh.fail(msg, result, stageSchema, err)
+	}
+
+	if mh.dryRun {
+		return mh.dryRunMessage(msg, writerMsg, rule, parser, result)
+	}
+
+	if err := mh.checkRevision(writerMsg, parser); err != nil {
+		logMonitoringEvent.
+			WithError(err).
+			Warn("Rejecting message older than the content already written")
+		return mh.fail(msg, result, stageStale, err)
+	}
+
+	var updatedContent string
+	result.Writes, updatedContent = mh.writeToCollections(writerMsg, pubEvent, rule, parser)
+	result.ContentUUID = result.Writes[0].ContentUUID
+	if writerErr := writeErrors(result.Writes); writerErr != nil {
 		logMonitoringEvent.
 			WithError(writerErr).
 			Error("Failed to write native content")
-		return
+		stage := stageWrite
+		var uuidErr *native.UUIDExtractionError
+		if errors.As(writerErr, &uuidErr) {
+			stage = stageUUIDExtraction
+		} else if errors.Is(writerErr, native.ErrStaleContent) {
+			stage = stageStale
+		}
+		return mh.fail(msg, result, stage, writerErr)
 	}
 
-	if mh.forwards {
+	if result.unchanged() {
+		result.Unchanged = true
+		if !mh.forwardsUnchanged {
+			return result
+		}
+		mh.setForwardBody(&pubEvent, writerMsg, rule, "")
+		return mh.forward(msg, pubEvent, rule, "", result, blockForward, logMonitoringEvent)
+	}
+	mh.recordRevision(writerMsg, result)
 
-		if writerMsg.IsPartialContent() {
-			pubEvent.Body = updatedContent
+	mh.setForwardBody(&pubEvent, writerMsg, rule, updatedContent)
+	return mh.forward(msg, pubEvent, rule, result.ContentUUID, result, blockForward, logMonitoringEvent)
+}
+
+// setForwardBody replaces the consumed body of the message to forward according to the forward body of its rule, or else
+// of the handler. The content returned by the native writer is only used when there is one, unless the message is partial content.
+func (mh *MessageHandler) setForwardBody(pubEvent *publicationEvent, writerMsg native.NativeMessage, rule config.OriginSystemConfig, updatedContent string) {
+	forwardBody := rule.ForwardBody
+	if forwardBody == "" {
+		forwardBody = mh.forwardBody
+	}
+	switch {
+	case writerMsg.IsPartialContent():
+		pubEvent.Body = updatedContent
+	case forwardBody == config.ForwardBodyStored && updatedContent != "":
+		pubEvent.Body = updatedContent
+	case forwardBody == config.ForwardBodyEnriched:
+		// the body sent to the native writer, which applies the rule transforms to a copy of the message
+		enriched := writerMsg
+		if len(rule.Transforms) > 0 && !enriched.IsDelete() {
+			enriched.Transform(rule.Transforms)
+		}
+		body, err := enriched.JSONBody()
+		if err != nil {
+			mh.logger.WithTransactionID(pubEvent.transactionID()).WithError(err).Warn("Failed to marshal the enriched body, forwarding the consumed body")
+			return
 		}
+		pubEvent.Body = body
+	}
+}
 
-		mh.logger.WithTransactionID(pubEvent.transactionID()).Info("Forwarding consumed message to different queue")
-		forwardErr := mh.producer.SendMessage(pubEvent.producerMsg())
-		if forwardErr != nil {
-			logMonitoringEvent.
+// writeToCollections writes the message to each collection of its rule, even if writing to one of them fails,
+// and returns the outcome of every write with the content updated by the native writer in the first collection
+func (mh *MessageHandler) writeToCollections(writerMsg native.NativeMessage, pubEvent publicationEvent, rule config.OriginSystemConfig, parser native.ContentBodyParser) ([]CollectionWrite, string) {
+	collections := rule.Collections()
+	writes := make([]CollectionWrite, 0, len(collections))
+	var updatedContent string
+	for i, collection := range collections {
+		if contentUUID, unchanged := mh.isUnchanged(writerMsg, parser, collection); unchanged {
+			mh.logger.WithTransactionID(pubEvent.transactionID()).
 				WithUUID(contentUUID).
-				WithError(forwardErr).
-				Error("Failed to forward consumed message to a different queue")
-			return
+				WithField("collection", collection).
+				Info("Native-Hash has not changed since the last write, skipping native writer")
+			metrics.MessagesUnchanged.WithLabelValues(pubEvent.originSystemID(), collection, pubEvent.messageType()).Inc()
+			writes = append(writes, CollectionWrite{Collection: collection, ContentUUID: contentUUID, Unchanged: true})
+			continue
+		}
+
+		contentUUID, updated, status, err := mh.writer.WriteToCollection(writerMsg, rule, collection)
+		writes = append(writes, CollectionWrite{Collection: collection, ContentUUID: contentUUID, Status: status, Err: err})
+		if err != nil {
+			continue
+		}
+		if i == 0 {
+			updatedContent = updated
 		}
+		metrics.MessagesWritten.WithLabelValues(pubEvent.originSystemID(), collection, pubEvent.messageType()).Inc()
+		mh.rememberHash(writerMsg, collection, contentUUID)
+	}
+	return writes, updatedContent
+}
+
+func (mh *MessageHandler) forward(msg kafka.FTMessage, pubEvent publicationEvent, rule config.OriginSystemConfig, contentUUID string, result Result, block bool, logMonitoringEvent *logger.LogEntry) Result {
+	if !mh.forwardsRule(rule) {
+		return result
+	}
+
+	mh.logger.WithTransactionID(pubEvent.transactionID()).WithField("forward_topic", rule.ForwardTopic).Info("Forwarding consumed message to different queue")
+	var forwardErr error
+	if producer := mh.producerFor(rule); producer == nil {
+		// the rule was added by a configuration reload, after the topic producers were set up
+		forwardErr = fmt.Errorf("no producer for forward topic %q, the service must be restarted to forward to it", rule.ForwardTopic)
+	} else {
+		forwardErr = mh.send(producer, pubEvent, result, block)
+	}
+	if forwardErr != nil {
 		logMonitoringEvent.
 			WithUUID(contentUUID).
-			Info("Successfully ingested")
+			WithError(forwardErr).
+			Error("Failed to forward consumed message to a different queue")
+		return mh.fail(msg, result, stageForward, forwardErr)
 	}
+	result.Forwarded = true
+	metrics.MessagesForwarded.WithLabelValues(pubEvent.originSystemID(), result.Collection, pubEvent.messageType()).Inc()
+	logMonitoringEvent.
+		WithUUID(contentUUID).
+		Info("Successfully ingested")
+	return result
+}
 
+// send forwards the message with the producer, retrying it according to the forward retry policy.
+// When block is true, it keeps retrying after the attempts of the policy are exhausted, until the message is forwarded.
+func (mh *MessageHandler) send(producer kafkaProducer, pubEvent publicationEvent, result Result, block bool) error {
+	log := mh.logger.WithTransactionID(pubEvent.transactionID())
+	blocked := false
+	for attempt := 1; ; attempt++ {
+		err := producer.SendMessage(pubEvent.producerMsg(result, mh.appName, time.Now()))
+		if err == nil {
+			if blocked {
+				metrics.ForwardsBlocked.Dec()
+				log.Info("Forwarded the consumed message, resuming consumption")
+			}
+			return nil
+		}
+		if attempt >= mh.forwardRetry.Attempts() {
+			if !block {
+				return err
+			}
+			if !blocked {
+				blocked = true
+				metrics.ForwardsBlocked.Inc()
+				log.WithError(err).Errorf("Failed to forward consumed message after %d attempts, blocking consumption until it is forwarded", attempt)
+			}
+		}
+		metrics.ForwardRetries.WithLabelValues(pubEvent.originSystemID(), result.Collection, pubEvent.messageType()).Inc()
+		wait := mh.forwardRetry.Backoff(attempt)
+		log.WithError(err).WithField("attempt", attempt).Warnf("Retrying to forward consumed message in %v", wait)
+		select {
+		case <-time.After(wait):
+		case <-mh.stop:
+			if blocked {
+				metrics.ForwardsBlocked.Dec()
+			}
+			return fmt.Errorf("handler stopped before the message was forwarded: %w", err)
+		}
+	}
+}
+
+// forwardsRule returns true if the messages routed by the rule are forwarded: to the topic of the rule if it names one,
+// or else to the producer topic, if any
+func (mh *MessageHandler) forwardsRule(rule config.OriginSystemConfig) bool {
+	if rule.SkipForward {
+		return false
+	}
+	return rule.ForwardTopic != "" || mh.forwards
+}
+
+// producerFor returns the producer forwarding the messages routed by the rule,
+// or nil if no producer was set up for the forward topic of the rule
+func (mh *MessageHandler) producerFor(rule config.OriginSystemConfig) kafkaProducer {
+	if rule.ForwardTopic == "" {
+		return mh.producer
+	}
+	return mh.topicProducers[rule.ForwardTopic]
 }
 
 // ForwardTo sets up the message producer to forward messages after writing in the native store
@@ -86,3 +377,214 @@ func (mh *MessageHandler) ForwardTo(p kafkaProducer) {
 	mh.producer = p
 	mh.forwards = true
 }
+
+// ForwardTopicTo sets up the message producer to forward the messages routed by the config rules naming the topic
+// as their forward topic. The messages of the other rules are still forwarded by the producer set up by ForwardTo.
+func (mh *MessageHandler) ForwardTopicTo(topic string, p kafkaProducer) {
+	if mh.topicProducers == nil {
+		mh.topicProducers = make(map[string]kafkaProducer)
+	}
+	mh.topicProducers[topic] = p
+}
+
+// ForwardBody sets up the body of the forwarded messages, one of the config.ForwardBody constants,
+// for the config rules that do not set their own
+func (mh *MessageHandler) ForwardBody(body string) {
+	mh.forwardBody = body
+}
+
+// RetryForward sets up the retries of the messages that could not be forwarded. Once the attempts of the policy are exhausted,
+// messages handled by HandleMessage are retried until they are forwarded if block is true, so that consumption stops until
+// the producer recovers. Otherwise they fail at the forward stage and are sent to the dead-letter queue, if it is set up.
+func (mh *MessageHandler) RetryForward(policy native.RetryPolicy, block bool) {
+	mh.forwardRetry = policy
+	mh.blocksForward = block
+}
+
+// Stop cancels the retries of the messages being forwarded, so that they fail at the forward stage
+// instead of blocking the shutdown of the consumer. It must be called before closing the consumer.
+func (mh *MessageHandler) Stop() {
+	mh.stopOnce.Do(func() { close(mh.stop) })
+}
+
+// IngestedBy sets the app name sent with the forwarded messages, in the X-Ingest-App header
+func (mh *MessageHandler) IngestedBy(appName string) {
+	mh.appName = appName
+}
+
+// DeadLetterTo sets up the message producer to send the messages that could not be processed
+func (mh *MessageHandler) DeadLetterTo(p kafkaProducer) {
+	mh.deadLetterProducer = p
+	mh.deadLetters = true
+}
+
+// DeleteOnBodyMarker sets up a marker ("path" or "path=value") that identifies delete events by their body,
+// in addition to the delete Message-Type header
+func (mh *MessageHandler) DeleteOnBodyMarker(marker string) {
+	mh.deleteBodyMarker = marker
+}
+
+// RecordTo sets up the recorder archiving every message consumed from the queue, before it is handled
+func (mh *MessageHandler) RecordTo(r messageRecorder) {
+	mh.recorder = r
+}
+
+// SkipUnchanged sets up the store of the hashes last written, so that messages with the same Native-Hash
+// as the last one written for their collection and content UUID are not written again.
+// Unchanged messages are still forwarded, unless skipForward is true.
+func (mh *MessageHandler) SkipUnchanged(store HashStore, skipForward bool) {
+	mh.hashStore = store
+	mh.forwardsUnchanged = !skipForward
+}
+
+// GuardRevisions sets up the guard rejecting messages whose integer X-Content-Revision is lower than the highest
+// one already written for their content UUID
+func (mh *MessageHandler) GuardRevisions(guard *RevisionGuard) {
+	mh.revisionGuard = guard
+}
+
+// DeadLetterStale sends the stale messages, rejected by the revision guard or by the native writer, to the dead-letter queue.
+// By default they are only logged and counted.
+func (mh *MessageHandler) DeadLetterStale() {
+	mh.deadLettersStale = true
+}
+
+// validateBody checks the body of a full content publish against the JSON Schema of the config rule routing it, if any.
+// Violations of a lenient rule schema are only logged. Deletes and partial content are not validated.
+func (mh *MessageHandler) validateBody(writerMsg native.NativeMessage, pubEvent publicationEvent, rule config.OriginSystemConfig) error {
+	if rule.BodySchema() == nil || writerMsg.IsDelete() || writerMsg.IsPartialContent() {
+		return nil
+	}
+
+	err := writerMsg.ValidateBody(rule.BodySchema())
+	var schemaErr *native.SchemaError
+	if !errors.As(err, &schemaErr) {
+		return err
+	}
+	mode := config.SchemaModeStrict
+	if rule.LenientSchema() {
+		mode = config.SchemaModeLenient
+	}
+	metrics.MessagesSchemaInvalid.WithLabelValues(mode, pubEvent.originSystemID(), rule.Collection, pubEvent.messageType()).Inc()
+	log := mh.logger.WithTransactionID(pubEvent.transactionID()).
+		WithField("collection", rule.Collection).
+		WithField("schema", rule.Schema).
+		WithField("schema_violations", schemaErr.Pointers())
+	for _, violation := range schemaErr.Violations {
+		log.WithField("json_pointer", violation.Pointer).Warnf("Schema violation at %q: %s", violation.Pointer, violation.Message)
+	}
+	if rule.LenientSchema() {
+		log.Warn("Native content does not match the lenient schema of its config rule, writing it anyway")
+		return nil
+	}
+	return err
+}
+
+func (mh *MessageHandler) checkRevision(writerMsg native.NativeMessage, parser native.ContentBodyParser) error {
+	if mh.revisionGuard == nil {
+		return nil
+	}
+	revision, ok := parseRevision(writerMsg)
+	if !ok {
+		return nil
+	}
+	contentUUID, err := writerMsg.ContentUUID(parser)
+	if err != nil {
+		return nil
+	}
+	return mh.revisionGuard.check(contentUUID, revision)
+}
+
+func (mh *MessageHandler) recordRevision(writerMsg native.NativeMessage, result Result) {
+	if mh.revisionGuard == nil || result.ContentUUID == "" {
+		return
+	}
+	if revision, ok := parseRevision(writerMsg); ok {
+		mh.revisionGuard.record(result.ContentUUID, revision)
+	}
+}
+
+// isUnchanged checks the hash of a full content publish against the last one written, and returns its content UUID.
+// Partial content and deletes are always written.
+func (mh *MessageHandler) isUnchanged(writerMsg native.NativeMessage, parser native.ContentBodyParser, collection string) (string, bool) {
+	if mh.hashStore == nil || writerMsg.NativeHash() == "" || writerMsg.IsPartialContent() || writerMsg.IsDelete() {
+		return "", false
+	}
+	contentUUID, err := writerMsg.ContentUUID(parser)
+	if err != nil {
+		return "", false
+	}
+	hash, found := mh.hashStore.Hash(collection, contentUUID)
+	return contentUUID, found && hash == writerMsg.NativeHash()
+}
+
+// rememberHash records the hash of the content written, or forgets it when the stored content no longer matches a hash
+func (mh *MessageHandler) rememberHash(writerMsg native.NativeMessage, collection string, contentUUID string) {
+	if mh.hashStore == nil || contentUUID == "" {
+		return
+	}
+	var err error
+	if writerMsg.NativeHash() == "" || writerMsg.IsPartialContent() || writerMsg.IsDelete() {
+		err = mh.hashStore.Forget(collection, contentUUID)
+	} else {
+		err = mh.hashStore.Store(collection, contentUUID, writerMsg.NativeHash())
+	}
+	if err != nil {
+		mh.logger.WithTransactionID(writerMsg.TransactionID()).WithUUID(contentUUID).WithError(err).Warn("Failed to update the native hash store")
+	}
+}
+
+// DryRun stops the handler from writing in the native store, forwarding or dead-lettering messages.
+// Messages are still routed and their UUID extracted, and what would have been done is logged.
+func (mh *MessageHandler) DryRun() {
+	mh.dryRun = true
+}
+
+func (mh *MessageHandler) dryRunMessage(msg kafka.FTMessage, writerMsg native.NativeMessage, rule config.OriginSystemConfig, parser native.ContentBodyParser, result Result) Result {
+	pubEvent := publicationEvent{msg}
+	result.DryRun = true
+	log := mh.logger.WithTransactionID(pubEvent.transactionID()).WithField("collection", result.Collection)
+
+	contentUUID, err := writerMsg.ContentUUID(parser)
+	if err != nil {
+		log.WithError(err).Error("Dry run: error extracting uuid, the message would be ignored")
+		return mh.fail(msg, result, stageUUIDExtraction, &native.UUIDExtractionError{Err: err})
+	}
+	result.ContentUUID = contentUUID
+
+	for _, collection := range rule.Collections() {
+		log.WithUUID(contentUUID).
+			WithField("collection", collection).
+			WithField("method", writerMsg.WriteMethod()).
+			WithField("forward", mh.forwardsRule(rule)).
+			WithField("forward_topic", rule.ForwardTopic).
+			Infof("Dry run: would %s content to the native store collection %s", writerMsg.WriteMethod(), collection)
+		metrics.MessagesDryRun.WithLabelValues(pubEvent.originSystemID(), collection, pubEvent.messageType()).Inc()
+	}
+	return result
+}
+
+func (mh *MessageHandler) fail(msg kafka.FTMessage, result Result, stage string, cause error) Result {
+	pubEvent := publicationEvent{msg}
+	metrics.MessagesFailed.WithLabelValues(stage, pubEvent.originSystemID(), result.Collection, pubEvent.messageType()).Inc()
+	if !mh.dryRun && (stage != stageStale || mh.deadLettersStale) {
+		mh.deadLetter(msg, stage, cause)
+	}
+	result.FailedStage = stage
+	result.Err = cause
+	return result
+}
+
+func (mh *MessageHandler) deadLetter(msg kafka.FTMessage, stage string, cause error) {
+	if !mh.deadLetters {
+		return
+	}
+
+	pubEvent := publicationEvent{msg}
+	log := mh.logger.WithTransactionID(pubEvent.transactionID()).WithField("stage", stage)
+	if err := mh.deadLetterProducer.SendMessage(deadLetterMsg(msg, stage, cause)); err != nil {
+		log.WithError(err).Error("Failed to send message to the dead-letter queue")
+		return
+	}
+	log.Info("Message sent to the dead-letter queue")
+}
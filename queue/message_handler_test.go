@@ -3,13 +3,24 @@ package queue
 import (
 	"bytes"
 	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/Financial-Times/go-logger/v2"
 	"github.com/Financial-Times/kafka-client-go/v4"
+	"github.com/Financial-Times/native-ingester/config"
+	"github.com/Financial-Times/native-ingester/metrics"
 	"github.com/Financial-Times/native-ingester/mocks"
+	"github.com/Financial-Times/native-ingester/native"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 const (
@@ -37,11 +48,13 @@ var badBodyMsg = kafka.FTMessage{
 	Headers: goodMsgHeaders,
 }
 
+var uuidBodyParser, _ = native.NewContentBodyParser([]string{"uuid"})
+
 func TestWriteToNativeSuccessfullyWithoutForward(t *testing.T) {
 	log := logger.NewUnstructuredLogger()
 	w := new(mocks.WriterMock)
-	w.On("GetCollection", cctOriginSystemID, contentType, []interface{}(nil)).Return(universalContentCollection, nil)
-	w.On("WriteToCollection", mock.AnythingOfType("native.NativeMessage"), universalContentCollection).Return("", "", nil)
+	w.On("GetRule", cctOriginSystemID, contentType, []interface{}(nil)).Return(config.OriginSystemConfig{Collection: universalContentCollection}, nil)
+	w.On("WriteToCollection", mock.AnythingOfType("native.NativeMessage"), universalContentCollection).Return("", "", http.StatusOK, nil)
 
 	p := new(mocks.ProducerMock)
 
@@ -56,8 +69,8 @@ func TestWriteToNativeSuccessfullyWithoutForward(t *testing.T) {
 func TestWriteToNativeSuccessfullyWithForward(t *testing.T) {
 	log := logger.NewUnstructuredLogger()
 	w := new(mocks.WriterMock)
-	w.On("GetCollection", cctOriginSystemID, contentType, []interface{}(nil)).Return(universalContentCollection, nil)
-	w.On("WriteToCollection", mock.AnythingOfType("native.NativeMessage"), universalContentCollection).Return("", "", nil)
+	w.On("GetRule", cctOriginSystemID, contentType, []interface{}(nil)).Return(config.OriginSystemConfig{Collection: universalContentCollection}, nil)
+	w.On("WriteToCollection", mock.AnythingOfType("native.NativeMessage"), universalContentCollection).Return("", "", http.StatusOK, nil)
 
 	p := new(mocks.ProducerMock)
 	p.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
@@ -77,13 +90,8 @@ func TestWritePartialContentToNativeSuccessfullyWithForward(t *testing.T) {
 	goodMsgPartialUpdated := goodMsg
 	goodMsgPartialUpdated.Headers[messageTypeHeader] = messageTypePartialContentPublished
 
-	expectedMessage := kafka.FTMessage{
-		Body:    updatedBody,
-		Headers: goodMsgPartialUpdated.Headers,
-	}
-
-	w.On("GetCollection", cctOriginSystemID, contentType, []interface{}(nil)).Return(universalContentCollection, nil)
-	w.On("WriteToCollection", mock.AnythingOfType("native.NativeMessage"), universalContentCollection).Return("", updatedBody, nil)
+	w.On("GetRule", cctOriginSystemID, contentType, []interface{}(nil)).Return(config.OriginSystemConfig{Collection: universalContentCollection}, nil)
+	w.On("WriteToCollection", mock.AnythingOfType("native.NativeMessage"), universalContentCollection).Return("", updatedBody, http.StatusOK, nil)
 
 	p := new(mocks.ProducerMock)
 	p.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
@@ -92,7 +100,9 @@ func TestWritePartialContentToNativeSuccessfullyWithForward(t *testing.T) {
 	mh.ForwardTo(p)
 	mh.HandleMessage(goodMsgPartialUpdated)
 
-	p.AssertCalled(t, "SendMessage", expectedMessage)
+	forwarded := p.Calls[0].Arguments.Get(0).(kafka.FTMessage)
+	assert.Equal(t, updatedBody, forwarded.Body)
+	assert.Equal(t, messageTypePartialContentPublished, forwarded.Headers[messageTypeHeader])
 	w.AssertExpectations(t)
 	p.AssertExpectations(t)
 }
@@ -114,7 +124,7 @@ func TestWriteToNativeFailWithBadBodyMessage(t *testing.T) {
 func TestWriteToNativeFailWithNotCollectionForOriginId(t *testing.T) {
 	log := logger.NewUnstructuredLogger()
 	w := new(mocks.WriterMock)
-	w.On("GetCollection", cctOriginSystemID, contentType, []interface{}(nil)).Return("", errors.New("Collection Not Found"))
+	w.On("GetRule", cctOriginSystemID, contentType, []interface{}(nil)).Return(config.OriginSystemConfig{}, errors.New("Collection Not Found"))
 
 	p := new(mocks.ProducerMock)
 
@@ -129,8 +139,8 @@ func TestWriteToNativeFailWithNotCollectionForOriginId(t *testing.T) {
 func TestWriteToNativeFailBecauseOfWriter(t *testing.T) {
 	log := logger.NewUnstructuredLogger()
 	w := new(mocks.WriterMock)
-	w.On("GetCollection", cctOriginSystemID, contentType, []interface{}(nil)).Return(universalContentCollection, nil)
-	w.On("WriteToCollection", mock.AnythingOfType("native.NativeMessage"), universalContentCollection).Return("", "", errors.New("today I do not want to write"))
+	w.On("GetRule", cctOriginSystemID, contentType, []interface{}(nil)).Return(config.OriginSystemConfig{Collection: universalContentCollection}, nil)
+	w.On("WriteToCollection", mock.AnythingOfType("native.NativeMessage"), universalContentCollection).Return("", "", 0, errors.New("today I do not want to write"))
 
 	p := new(mocks.ProducerMock)
 
@@ -149,8 +159,8 @@ func TestForwardFailBecauseOfProducer(t *testing.T) {
 	hook.SetOutput(&buf)
 
 	w := new(mocks.WriterMock)
-	w.On("GetCollection", cctOriginSystemID, contentType, []interface{}(nil)).Return(universalContentCollection, nil)
-	w.On("WriteToCollection", mock.AnythingOfType("native.NativeMessage"), universalContentCollection).Return("", "", nil)
+	w.On("GetRule", cctOriginSystemID, contentType, []interface{}(nil)).Return(config.OriginSystemConfig{Collection: universalContentCollection}, nil)
+	w.On("WriteToCollection", mock.AnythingOfType("native.NativeMessage"), universalContentCollection).Return("", "", http.StatusOK, nil)
 
 	p := new(mocks.ProducerMock)
 	p.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(errors.New("Today, I am not writing on a queue."))
@@ -164,3 +174,759 @@ func TestForwardFailBecauseOfProducer(t *testing.T) {
 	assert.Contains(t, "error", buf.String())
 	assert.Contains(t, "Failed to forward consumed message to a different queue", buf.String())
 }
+
+func TestForwardToTopicOfRule(t *testing.T) {
+	tests := []struct {
+		name         string
+		rule         config.OriginSystemConfig
+		defaultCalls int
+		topicCalls   int
+		forwarded    bool
+		failedStage  string
+	}{
+		{"default topic", config.OriginSystemConfig{Collection: universalContentCollection}, 1, 0, true, ""},
+		{"topic of the rule", config.OriginSystemConfig{Collection: universalContentCollection, ForwardTopic: "NativePacEvents"}, 0, 1, true, ""},
+		{"forward skipped", config.OriginSystemConfig{Collection: universalContentCollection, SkipForward: true}, 0, 0, false, ""},
+		{"topic without producer", config.OriginSystemConfig{Collection: universalContentCollection, ForwardTopic: "NativeNewEvents"}, 0, 0, false, stageForward},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := logger.NewUnstructuredLogger()
+			w := new(mocks.WriterMock)
+			w.On("GetRule", cctOriginSystemID, contentType, []interface{}(nil)).Return(tt.rule, nil)
+			w.On("WriteToCollection", mock.AnythingOfType("native.NativeMessage"), universalContentCollection).Return("572d0acc-3f12-4e70-8830-8092c1042a52", "", http.StatusOK, nil)
+			defaultProducer := new(mocks.ProducerMock)
+			defaultProducer.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+			topicProducer := new(mocks.ProducerMock)
+			topicProducer.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+
+			mh := NewMessageHandler(w, contentType, log)
+			mh.ForwardTo(defaultProducer)
+			mh.ForwardTopicTo("NativePacEvents", topicProducer)
+			result := mh.ProcessMessage(hashedMsg("", ""))
+
+			assert.Equal(t, tt.forwarded, result.Forwarded)
+			assert.Equal(t, tt.failedStage, result.FailedStage)
+			defaultProducer.AssertNumberOfCalls(t, "SendMessage", tt.defaultCalls)
+			topicProducer.AssertNumberOfCalls(t, "SendMessage", tt.topicCalls)
+		})
+	}
+}
+
+func TestForwardToTopicOfRuleWithoutDefaultProducer(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	w := new(mocks.WriterMock)
+	w.On("GetRule", cctOriginSystemID, contentType, []interface{}(nil)).Return(config.OriginSystemConfig{Collection: universalContentCollection, ForwardTopic: "NativePacEvents"}, nil)
+	w.On("WriteToCollection", mock.AnythingOfType("native.NativeMessage"), universalContentCollection).Return("572d0acc-3f12-4e70-8830-8092c1042a52", "", http.StatusOK, nil)
+	p := new(mocks.ProducerMock)
+	p.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+
+	mh := NewMessageHandler(w, contentType, log)
+	mh.ForwardTopicTo("NativePacEvents", p)
+
+	assert.True(t, mh.ProcessMessage(hashedMsg("", "")).Forwarded)
+	p.AssertExpectations(t)
+}
+
+func TestForwardBody(t *testing.T) {
+	const stored = `{"uuid":"572d0acc-3f12-4e70-8830-8092c1042a52","title":"stored"}`
+	tests := []struct {
+		name        string
+		handlerBody string
+		ruleBody    string
+		messageType string
+		response    string
+		want        string
+	}{
+		{"original by default", "", "", "cms-content-published", stored, `{"uuid":"572d0acc-3f12-4e70-8830-8092c1042a52"}`},
+		{"original partial content", "", "", messageTypePartialContentPublished, stored, stored},
+		{"stored", config.ForwardBodyStored, "", "cms-content-published", stored, stored},
+		{"stored without response", config.ForwardBodyStored, "", "cms-content-published", "", `{"uuid":"572d0acc-3f12-4e70-8830-8092c1042a52"}`},
+		{"enriched", config.ForwardBodyEnriched, "", "cms-content-published", stored, `{"lastModified":"2017-02-16T12:56:16Z","publishReference":"tid_test","uuid":"572d0acc-3f12-4e70-8830-8092c1042a52"}`},
+		{"enriched partial content", config.ForwardBodyEnriched, "", messageTypePartialContentPublished, stored, stored},
+		{"rule overrides handler", config.ForwardBodyEnriched, config.ForwardBodyStored, "cms-content-published", stored, stored},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := logger.NewUnstructuredLogger()
+			w := new(mocks.WriterMock)
+			w.On("GetRule", cctOriginSystemID, contentType, []interface{}(nil)).Return(config.OriginSystemConfig{Collection: universalContentCollection, ForwardBody: tt.ruleBody}, nil)
+			w.On("WriteToCollection", mock.AnythingOfType("native.NativeMessage"), universalContentCollection).Return("572d0acc-3f12-4e70-8830-8092c1042a52", tt.response, http.StatusOK, nil)
+			p := new(mocks.ProducerMock)
+			p.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+
+			mh := NewMessageHandler(w, contentType, log)
+			mh.ForwardTo(p)
+			mh.ForwardBody(tt.handlerBody)
+			require.True(t, mh.ProcessMessage(hashedMsg("", tt.messageType)).Forwarded)
+
+			assert.Equal(t, tt.want, p.Calls[0].Arguments.Get(0).(kafka.FTMessage).Body)
+		})
+	}
+}
+
+func TestEnrichedForwardBodyIsTransformed(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	rule := config.OriginSystemConfig{
+		Collection:  universalContentCollection,
+		ForwardBody: config.ForwardBodyEnriched,
+		Transforms:  []config.Transform{{Remove: "publishReference"}, {Set: "source", Value: "cct"}},
+	}
+	w := new(mocks.WriterMock)
+	w.On("GetRule", cctOriginSystemID, contentType, []interface{}(nil)).Return(rule, nil)
+	w.On("WriteToCollection", mock.AnythingOfType("native.NativeMessage"), universalContentCollection).Return("572d0acc-3f12-4e70-8830-8092c1042a52", "", http.StatusOK, nil)
+	p := new(mocks.ProducerMock)
+	p.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+
+	mh := NewMessageHandler(w, contentType, log)
+	mh.ForwardTo(p)
+	require.True(t, mh.ProcessMessage(hashedMsg("", "")).Forwarded)
+
+	assert.JSONEq(t, `{"lastModified":"2017-02-16T12:56:16Z","source":"cct","uuid":"572d0acc-3f12-4e70-8830-8092c1042a52"}`,
+		p.Calls[0].Arguments.Get(0).(kafka.FTMessage).Body, "The body sent to the native writer should be forwarded")
+}
+
+var testForwardRetryPolicy = native.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+
+func TestForwardIsRetried(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	w := new(mocks.WriterMock)
+	w.On("GetRule", cctOriginSystemID, contentType, []interface{}(nil)).Return(config.OriginSystemConfig{Collection: universalContentCollection}, nil)
+	w.On("WriteToCollection", mock.AnythingOfType("native.NativeMessage"), universalContentCollection).Return("572d0acc-3f12-4e70-8830-8092c1042a52", "", http.StatusOK, nil)
+	p := new(mocks.ProducerMock)
+	p.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(errors.New("broker not available")).Twice()
+	p.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil).Once()
+
+	mh := NewMessageHandler(w, contentType, log)
+	mh.ForwardTo(p)
+	mh.RetryForward(testForwardRetryPolicy, false)
+	result := mh.ProcessMessage(hashedMsg("", ""))
+
+	assert.True(t, result.Forwarded)
+	p.AssertNumberOfCalls(t, "SendMessage", 3)
+}
+
+func TestForwardFailsAfterRetries(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	w := new(mocks.WriterMock)
+	w.On("GetRule", cctOriginSystemID, contentType, []interface{}(nil)).Return(config.OriginSystemConfig{Collection: universalContentCollection}, nil)
+	w.On("WriteToCollection", mock.AnythingOfType("native.NativeMessage"), universalContentCollection).Return("572d0acc-3f12-4e70-8830-8092c1042a52", "", http.StatusOK, nil)
+	p := new(mocks.ProducerMock)
+	p.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(errors.New("broker not available"))
+	dlp := new(mocks.ProducerMock)
+	dlp.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+
+	mh := NewMessageHandler(w, contentType, log)
+	mh.ForwardTo(p)
+	mh.DeadLetterTo(dlp)
+	mh.RetryForward(testForwardRetryPolicy, false)
+	result := mh.ProcessMessage(hashedMsg("", ""))
+
+	assert.False(t, result.Forwarded)
+	assert.Equal(t, stageForward, result.FailedStage)
+	p.AssertNumberOfCalls(t, "SendMessage", 3)
+	dlp.AssertNumberOfCalls(t, "SendMessage", 1)
+}
+
+func TestForwardBlocksUntilItSucceeds(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	w := new(mocks.WriterMock)
+	w.On("GetRule", cctOriginSystemID, contentType, []interface{}(nil)).Return(config.OriginSystemConfig{Collection: universalContentCollection}, nil)
+	w.On("WriteToCollection", mock.AnythingOfType("native.NativeMessage"), universalContentCollection).Return("572d0acc-3f12-4e70-8830-8092c1042a52", "", http.StatusOK, nil)
+	p := new(mocks.ProducerMock)
+	p.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(errors.New("broker not available")).Times(6)
+	p.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil).Once()
+	dlp := new(mocks.ProducerMock)
+
+	mh := NewMessageHandler(w, contentType, log)
+	mh.ForwardTo(p)
+	mh.DeadLetterTo(dlp)
+	mh.RetryForward(testForwardRetryPolicy, true)
+	mh.HandleMessage(hashedMsg("", ""))
+
+	p.AssertNumberOfCalls(t, "SendMessage", 7)
+	dlp.AssertNotCalled(t, "SendMessage", mock.Anything)
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.ForwardsBlocked), "The message should no longer block consumption")
+}
+
+func TestStopCancelsBlockedForward(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	w := new(mocks.WriterMock)
+	w.On("GetRule", cctOriginSystemID, contentType, []interface{}(nil)).Return(config.OriginSystemConfig{Collection: universalContentCollection}, nil)
+	w.On("WriteToCollection", mock.AnythingOfType("native.NativeMessage"), universalContentCollection).Return("572d0acc-3f12-4e70-8830-8092c1042a52", "", http.StatusOK, nil)
+	p := new(mocks.ProducerMock)
+	p.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(errors.New("broker not available"))
+	dlp := new(mocks.ProducerMock)
+	dlp.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+
+	mh := NewMessageHandler(w, contentType, log)
+	mh.ForwardTo(p)
+	mh.DeadLetterTo(dlp)
+	mh.RetryForward(testForwardRetryPolicy, true)
+
+	handled := make(chan struct{})
+	go func() {
+		mh.HandleMessage(hashedMsg("", ""))
+		close(handled)
+	}()
+	require.Eventually(t, func() bool { return testutil.ToFloat64(metrics.ForwardsBlocked) == 1 }, time.Second, time.Millisecond,
+		"The message should block consumption")
+
+	mh.Stop()
+	select {
+	case <-handled:
+	case <-time.After(time.Second):
+		t.Fatal("Stopping the handler should cancel the blocked forward")
+	}
+	dlp.AssertNumberOfCalls(t, "SendMessage", 1)
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.ForwardsBlocked), "The message should no longer block consumption")
+}
+
+func TestDeadLetterBadBodyMessage(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	w := new(mocks.WriterMock)
+	p := new(mocks.ProducerMock)
+	dlq := new(mocks.ProducerMock)
+	dlq.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+
+	msg := badBodyMsg
+	msg.Topic = "PreNativeCmsPublicationEvents"
+	mh := NewMessageHandler(w, contentType, log)
+	mh.ForwardTo(p)
+	mh.DeadLetterTo(dlq)
+	mh.HandleMessage(msg)
+
+	w.AssertExpectations(t)
+	p.AssertExpectations(t)
+	dlq.AssertExpectations(t)
+
+	dlqMsg := dlq.Calls[0].Arguments.Get(0).(kafka.FTMessage)
+	assert.Equal(t, badBodyMsg.Body, dlqMsg.Body)
+	assert.Equal(t, "tid_test", dlqMsg.Headers["X-Request-Id"])
+	assert.Equal(t, stageUnmarshal, dlqMsg.Headers[deadLetterStageHeader])
+	assert.Equal(t, "invalid character  I  looking for beginning of value", dlqMsg.Headers[deadLetterErrorHeader])
+	assert.Equal(t, "PreNativeCmsPublicationEvents", dlqMsg.Headers[deadLetterSourceTopicHeader])
+	assert.Equal(t, "1", dlqMsg.Headers[deadLetterAttemptHeader])
+	assert.NotContains(t, badBodyMsg.Headers, deadLetterStageHeader, "The original message should not be modified")
+}
+
+func TestDeadLetterMessageWithoutTimestamp(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	w := new(mocks.WriterMock)
+	dlq := new(mocks.ProducerMock)
+	dlq.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+
+	msg := kafka.FTMessage{
+		Body: "{}",
+		Headers: map[string]string{
+			"X-Request-Id":          "tid_test",
+			deadLetterAttemptHeader: "2",
+		},
+	}
+	mh := NewMessageHandler(w, contentType, log)
+	mh.DeadLetterTo(dlq)
+	mh.HandleMessage(msg)
+
+	dlqMsg := dlq.Calls[0].Arguments.Get(0).(kafka.FTMessage)
+	assert.Equal(t, stageTimestamp, dlqMsg.Headers[deadLetterStageHeader])
+	assert.Equal(t, "3", dlqMsg.Headers[deadLetterAttemptHeader], "A replayed message should increase the attempt count")
+}
+
+func TestDeadLetterBecauseOfWriter(t *testing.T) {
+	tests := []struct {
+		name      string
+		writerErr error
+		stage     string
+	}{
+		{"write failure", errors.New("today I do not want to write"), stageWrite},
+		{"uuid failure", &native.UUIDExtractionError{Err: errors.New("UUID not found")}, stageUUIDExtraction},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := logger.NewUnstructuredLogger()
+			w := new(mocks.WriterMock)
+			w.On("GetRule", cctOriginSystemID, contentType, []interface{}(nil)).Return(config.OriginSystemConfig{Collection: universalContentCollection}, nil)
+			w.On("WriteToCollection", mock.AnythingOfType("native.NativeMessage"), universalContentCollection).Return("", "", 0, tt.writerErr)
+			dlq := new(mocks.ProducerMock)
+			dlq.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+
+			mh := NewMessageHandler(w, contentType, log)
+			mh.DeadLetterTo(dlq)
+			mh.HandleMessage(goodMsg)
+
+			w.AssertExpectations(t)
+			dlqMsg := dlq.Calls[0].Arguments.Get(0).(kafka.FTMessage)
+			assert.Equal(t, tt.stage, dlqMsg.Headers[deadLetterStageHeader])
+		})
+	}
+}
+
+func TestDeadLetterBecauseOfProducer(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	w := new(mocks.WriterMock)
+	w.On("GetRule", cctOriginSystemID, contentType, []interface{}(nil)).Return(config.OriginSystemConfig{Collection: universalContentCollection}, nil)
+	w.On("WriteToCollection", mock.AnythingOfType("native.NativeMessage"), universalContentCollection).Return("", "", http.StatusOK, nil)
+	p := new(mocks.ProducerMock)
+	p.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(errors.New("Today, I am not writing on a queue."))
+	dlq := new(mocks.ProducerMock)
+	dlq.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+
+	mh := NewMessageHandler(w, contentType, log)
+	mh.ForwardTo(p)
+	mh.DeadLetterTo(dlq)
+	mh.HandleMessage(goodMsg)
+
+	dlqMsg := dlq.Calls[0].Arguments.Get(0).(kafka.FTMessage)
+	assert.Equal(t, stageForward, dlqMsg.Headers[deadLetterStageHeader])
+	assert.Equal(t, goodMsg.Body, dlqMsg.Body)
+}
+
+func TestNoDeadLetterForNotWhitelistedMessage(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	w := new(mocks.WriterMock)
+	w.On("GetRule", cctOriginSystemID, contentType, []interface{}(nil)).Return(config.OriginSystemConfig{}, errors.New("Collection Not Found"))
+	dlq := new(mocks.ProducerMock)
+
+	mh := NewMessageHandler(w, contentType, log)
+	mh.DeadLetterTo(dlq)
+	mh.HandleMessage(goodMsg)
+
+	w.AssertExpectations(t)
+	dlq.AssertExpectations(t)
+}
+
+func TestHandleMessageRecordsMetrics(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	msgType := "cms-content-published"
+	msg := kafka.FTMessage{
+		Body: "{}",
+		Headers: map[string]string{
+			"Content-Type":      contentType,
+			"X-Request-Id":      "tid_test",
+			"Message-Timestamp": "2017-02-16T12:56:16Z",
+			"Origin-System-Id":  cctOriginSystemID,
+			"Message-Type":      msgType,
+		},
+	}
+	labels := []string{cctOriginSystemID, universalContentCollection, msgType}
+
+	consumed := testutil.ToFloat64(metrics.MessagesConsumed.WithLabelValues(labels...))
+	written := testutil.ToFloat64(metrics.MessagesWritten.WithLabelValues(labels...))
+	forwarded := testutil.ToFloat64(metrics.MessagesForwarded.WithLabelValues(labels...))
+	failed := testutil.ToFloat64(metrics.MessagesFailed.WithLabelValues(append([]string{stageForward}, labels...)...))
+
+	w := new(mocks.WriterMock)
+	w.On("GetRule", cctOriginSystemID, contentType, []interface{}(nil)).Return(config.OriginSystemConfig{Collection: universalContentCollection}, nil)
+	w.On("WriteToCollection", mock.AnythingOfType("native.NativeMessage"), universalContentCollection).Return("", "", http.StatusOK, nil)
+	p := new(mocks.ProducerMock)
+	p.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil).Once()
+	p.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(errors.New("Today, I am not writing on a queue."))
+
+	mh := NewMessageHandler(w, contentType, log)
+	mh.ForwardTo(p)
+	mh.HandleMessage(msg)
+	mh.HandleMessage(msg)
+
+	assert.Equal(t, consumed+2, testutil.ToFloat64(metrics.MessagesConsumed.WithLabelValues(labels...)))
+	assert.Equal(t, written+2, testutil.ToFloat64(metrics.MessagesWritten.WithLabelValues(labels...)))
+	assert.Equal(t, forwarded+1, testutil.ToFloat64(metrics.MessagesForwarded.WithLabelValues(labels...)))
+	assert.Equal(t, failed+1, testutil.ToFloat64(metrics.MessagesFailed.WithLabelValues(append([]string{stageForward}, labels...)...)))
+}
+
+func TestHandleMessageRecordsSkippedMetric(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	labels := []string{cctOriginSystemID, "", goodMsg.Headers["Message-Type"]}
+	skipped := testutil.ToFloat64(metrics.MessagesSkipped.WithLabelValues(labels...))
+
+	w := new(mocks.WriterMock)
+	w.On("GetRule", cctOriginSystemID, contentType, []interface{}(nil)).Return(config.OriginSystemConfig{}, errors.New("Collection Not Found"))
+
+	mh := NewMessageHandler(w, contentType, log)
+	mh.HandleMessage(goodMsg)
+
+	assert.Equal(t, skipped+1, testutil.ToFloat64(metrics.MessagesSkipped.WithLabelValues(labels...)))
+}
+
+func TestDeleteEventByBodyMarkerIsWrittenAndForwarded(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	msg := kafka.FTMessage{
+		Body: `{"uuid":"572d0acc-3f12-4e70-8830-8092c1042a52","deleted":true}`,
+		Headers: map[string]string{
+			"Content-Type":      contentType,
+			"X-Request-Id":      "tid_test",
+			"Message-Timestamp": "2017-02-16T12:56:16Z",
+			"Origin-System-Id":  cctOriginSystemID,
+		},
+	}
+	isDelete := mock.MatchedBy(func(msg native.NativeMessage) bool {
+		return msg.IsDelete()
+	})
+
+	w := new(mocks.WriterMock)
+	w.On("GetRule", cctOriginSystemID, contentType, []interface{}(nil)).Return(config.OriginSystemConfig{Collection: universalContentCollection}, nil)
+	w.On("WriteToCollection", isDelete, universalContentCollection).Return("572d0acc-3f12-4e70-8830-8092c1042a52", "", http.StatusOK, nil)
+	p := new(mocks.ProducerMock)
+	p.On("SendMessage", mock.MatchedBy(func(forwarded kafka.FTMessage) bool {
+		return forwarded.Body == msg.Body && forwarded.Headers[messageTypeHeader] == "cms-content-deleted"
+	})).Return(nil)
+
+	mh := NewMessageHandler(w, contentType, log)
+	mh.ForwardTo(p)
+	mh.DeleteOnBodyMarker("deleted")
+	mh.HandleMessage(msg)
+
+	w.AssertExpectations(t)
+	p.AssertExpectations(t)
+	assert.NotContains(t, msg.Headers, messageTypeHeader, "The headers of the consumed message should not change")
+}
+
+func TestDryRunDoesNotWriteOrForward(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	msg := kafka.FTMessage{
+		Body:    `{"uuid":"572d0acc-3f12-4e70-8830-8092c1042a52"}`,
+		Headers: goodMsgHeaders,
+	}
+	labels := []string{cctOriginSystemID, universalContentCollection, goodMsgHeaders[messageTypeHeader]}
+	dryRun := testutil.ToFloat64(metrics.MessagesDryRun.WithLabelValues(labels...))
+
+	w := new(mocks.WriterMock)
+	w.Parser = uuidBodyParser
+	w.On("GetRule", cctOriginSystemID, contentType, []interface{}(nil)).Return(config.OriginSystemConfig{Collection: universalContentCollection}, nil)
+	p := new(mocks.ProducerMock)
+
+	mh := NewMessageHandler(w, contentType, log)
+	mh.ForwardTo(p)
+	mh.DryRun()
+	result := mh.ProcessMessage(msg)
+
+	w.AssertExpectations(t)
+	w.AssertNotCalled(t, "WriteToCollection", mock.Anything, mock.Anything)
+	p.AssertNotCalled(t, "SendMessage", mock.Anything)
+	assert.Equal(t, Result{Collection: universalContentCollection, ContentUUID: "572d0acc-3f12-4e70-8830-8092c1042a52", DryRun: true}, result)
+	assert.Equal(t, dryRun+1, testutil.ToFloat64(metrics.MessagesDryRun.WithLabelValues(labels...)))
+}
+
+func TestDryRunDoesNotDeadLetter(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	w := new(mocks.WriterMock)
+	w.Parser = uuidBodyParser
+	w.On("GetRule", cctOriginSystemID, contentType, []interface{}(nil)).Return(config.OriginSystemConfig{Collection: universalContentCollection}, nil)
+	dlq := new(mocks.ProducerMock)
+
+	mh := NewMessageHandler(w, contentType, log)
+	mh.DeadLetterTo(dlq)
+	mh.DryRun()
+	result := mh.ProcessMessage(goodMsg)
+
+	dlq.AssertNotCalled(t, "SendMessage", mock.Anything)
+	assert.Equal(t, stageUUIDExtraction, result.FailedStage)
+	assert.True(t, result.DryRun)
+	assert.True(t, result.InvalidMessage())
+}
+
+type recorderStub struct {
+	msgs []kafka.FTMessage
+}
+
+func (r *recorderStub) Record(msg kafka.FTMessage) error {
+	r.msgs = append(r.msgs, msg)
+	return nil
+}
+
+func TestConsumedMessagesAreRecorded(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	w := new(mocks.WriterMock)
+	w.On("GetRule", cctOriginSystemID, contentType, []interface{}(nil)).Return(config.OriginSystemConfig{}, errors.New("Collection Not Found"))
+	r := &recorderStub{}
+
+	mh := NewMessageHandler(w, contentType, log)
+	mh.RecordTo(r)
+	mh.HandleMessage(badBodyMsg)
+	mh.HandleMessage(goodMsg)
+	mh.ProcessMessage(goodMsg)
+
+	assert.Equal(t, []kafka.FTMessage{badBodyMsg, goodMsg}, r.msgs, "Only consumed messages should be recorded, even if they cannot be processed")
+}
+
+func hashedMsg(hash string, messageType string) kafka.FTMessage {
+	return kafka.FTMessage{
+		Body: `{"uuid":"572d0acc-3f12-4e70-8830-8092c1042a52"}`,
+		Headers: map[string]string{
+			"Content-Type":      contentType,
+			"X-Request-Id":      "tid_test",
+			"Message-Timestamp": "2017-02-16T12:56:16Z",
+			"Origin-System-Id":  cctOriginSystemID,
+			"Message-Type":      messageType,
+			"Native-Hash":       hash,
+		},
+	}
+}
+
+func TestUnchangedMessagesAreNotWritten(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	w := new(mocks.WriterMock)
+	w.Parser = uuidBodyParser
+	w.On("GetRule", cctOriginSystemID, contentType, []interface{}(nil)).Return(config.OriginSystemConfig{Collection: universalContentCollection}, nil)
+	w.On("WriteToCollection", mock.AnythingOfType("native.NativeMessage"), universalContentCollection).Return("572d0acc-3f12-4e70-8830-8092c1042a52", "", http.StatusOK, nil)
+	p := new(mocks.ProducerMock)
+	p.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+
+	mh := NewMessageHandler(w, contentType, log)
+	mh.ForwardTo(p)
+	mh.SkipUnchanged(NewLRUHashStore(10), false)
+
+	assert.False(t, mh.ProcessMessage(hashedMsg("hash-1", "cms-content-published")).Unchanged)
+	result := mh.ProcessMessage(hashedMsg("hash-1", "cms-content-published"))
+	assert.True(t, result.Unchanged)
+	assert.True(t, result.Forwarded, "Unchanged messages should still be forwarded")
+	assert.Equal(t, "572d0acc-3f12-4e70-8830-8092c1042a52", result.ContentUUID)
+	assert.False(t, mh.ProcessMessage(hashedMsg("hash-2", "cms-content-published")).Unchanged)
+
+	w.AssertNumberOfCalls(t, "WriteToCollection", 2)
+	p.AssertNumberOfCalls(t, "SendMessage", 3)
+}
+
+func TestUnchangedMessagesAreNotForwarded(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	w := new(mocks.WriterMock)
+	w.Parser = uuidBodyParser
+	w.On("GetRule", cctOriginSystemID, contentType, []interface{}(nil)).Return(config.OriginSystemConfig{Collection: universalContentCollection}, nil)
+	w.On("WriteToCollection", mock.AnythingOfType("native.NativeMessage"), universalContentCollection).Return("572d0acc-3f12-4e70-8830-8092c1042a52", "", http.StatusOK, nil)
+	p := new(mocks.ProducerMock)
+	p.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+
+	mh := NewMessageHandler(w, contentType, log)
+	mh.ForwardTo(p)
+	mh.SkipUnchanged(NewLRUHashStore(10), true)
+
+	mh.ProcessMessage(hashedMsg("hash-1", "cms-content-published"))
+	result := mh.ProcessMessage(hashedMsg("hash-1", "cms-content-published"))
+
+	assert.True(t, result.Unchanged)
+	assert.False(t, result.Forwarded)
+	w.AssertNumberOfCalls(t, "WriteToCollection", 1)
+	p.AssertNumberOfCalls(t, "SendMessage", 1)
+}
+
+func TestContentIsWrittenAgainAfterDelete(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	w := new(mocks.WriterMock)
+	w.Parser = uuidBodyParser
+	w.On("GetRule", cctOriginSystemID, contentType, []interface{}(nil)).Return(config.OriginSystemConfig{Collection: universalContentCollection}, nil)
+	w.On("WriteToCollection", mock.AnythingOfType("native.NativeMessage"), universalContentCollection).Return("572d0acc-3f12-4e70-8830-8092c1042a52", "", http.StatusOK, nil)
+
+	mh := NewMessageHandler(w, contentType, log)
+	mh.SkipUnchanged(NewLRUHashStore(10), false)
+
+	mh.ProcessMessage(hashedMsg("hash-1", "cms-content-published"))
+	mh.ProcessMessage(hashedMsg("hash-1", "cms-content-deleted"))
+	assert.False(t, mh.ProcessMessage(hashedMsg("hash-1", "cms-content-published")).Unchanged)
+	mh.ProcessMessage(hashedMsg("hash-1", messageTypePartialContentPublished))
+	assert.False(t, mh.ProcessMessage(hashedMsg("hash-1", "cms-content-published")).Unchanged)
+
+	w.AssertNumberOfCalls(t, "WriteToCollection", 5)
+}
+
+func revisionMsg(revision string) kafka.FTMessage {
+	return kafka.FTMessage{
+		Body: `{"uuid":"572d0acc-3f12-4e70-8830-8092c1042a52"}`,
+		Headers: map[string]string{
+			"Content-Type":       contentType,
+			"X-Request-Id":       "tid_test",
+			"Message-Timestamp":  "2017-02-16T12:56:16Z",
+			"Origin-System-Id":   cctOriginSystemID,
+			"X-Content-Revision": revision,
+		},
+	}
+}
+
+func TestStaleRevisionsAreRejected(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	w := new(mocks.WriterMock)
+	w.Parser = uuidBodyParser
+	w.On("GetRule", cctOriginSystemID, contentType, []interface{}(nil)).Return(config.OriginSystemConfig{Collection: universalContentCollection}, nil)
+	w.On("WriteToCollection", mock.AnythingOfType("native.NativeMessage"), universalContentCollection).Return("572d0acc-3f12-4e70-8830-8092c1042a52", "", http.StatusOK, nil)
+	p := new(mocks.ProducerMock)
+	p.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+	dlq := new(mocks.ProducerMock)
+
+	mh := NewMessageHandler(w, contentType, log)
+	mh.ForwardTo(p)
+	mh.DeadLetterTo(dlq)
+	mh.GuardRevisions(NewRevisionGuard(10))
+
+	assert.NoError(t, mh.ProcessMessage(revisionMsg("5")).Err)
+	result := mh.ProcessMessage(revisionMsg("4"))
+	assert.True(t, result.Stale())
+	assert.ErrorIs(t, result.Err, native.ErrStaleContent)
+	assert.NoError(t, mh.ProcessMessage(revisionMsg("not a number")).Err, "Non integer revisions should not be guarded")
+	assert.NoError(t, mh.ProcessMessage(revisionMsg("6")).Err)
+
+	w.AssertNumberOfCalls(t, "WriteToCollection", 3)
+	p.AssertNumberOfCalls(t, "SendMessage", 3)
+	dlq.AssertNotCalled(t, "SendMessage", mock.Anything)
+}
+
+func TestRuleUUIDFieldsAreUsedAtEveryStep(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	w := new(mocks.WriterMock)
+	w.Parser = uuidBodyParser
+	w.On("GetRule", cctOriginSystemID, contentType, []interface{}(nil)).Return(config.OriginSystemConfig{Collection: universalContentCollection, UUIDFields: []string{"id"}}, nil)
+	w.On("WriteToCollection", mock.AnythingOfType("native.NativeMessage"), universalContentCollection).Return("07ac9fad-6434-47c7-b7c4-34361a048d07", "", http.StatusOK, nil)
+
+	mh := NewMessageHandler(w, contentType, log)
+	mh.GuardRevisions(NewRevisionGuard(10))
+	mh.SkipUnchanged(NewLRUHashStore(10), false)
+
+	ruleMsg := func(revision string, hash string, uuid string) kafka.FTMessage {
+		msg := revisionMsg(revision)
+		msg.Body = `{"uuid":"` + uuid + `","id":"07ac9fad-6434-47c7-b7c4-34361a048d07"}`
+		msg.Headers["Native-Hash"] = hash
+		return msg
+	}
+	assert.NoError(t, mh.ProcessMessage(ruleMsg("5", "hash-1", "572d0acc-3f12-4e70-8830-8092c1042a52")).Err)
+	assert.True(t, mh.ProcessMessage(ruleMsg("4", "hash-2", "8e6c705e-1132-42a2-8db0-c295e29e8658")).Stale(),
+		"The revision should be checked against the content UUID found at the uuid_fields of the rule")
+	assert.True(t, mh.ProcessMessage(ruleMsg("6", "hash-1", "8e6c705e-1132-42a2-8db0-c295e29e8658")).Unchanged,
+		"The hash should be looked up with the content UUID found at the uuid_fields of the rule")
+
+	mh.DryRun()
+	result := mh.ProcessMessage(ruleMsg("7", "hash-3", "not a uuid"))
+	assert.Equal(t, "07ac9fad-6434-47c7-b7c4-34361a048d07", result.ContentUUID, "A dry run should use the uuid_fields of the rule")
+	w.AssertNumberOfCalls(t, "WriteToCollection", 1)
+}
+
+func TestStaleMessagesAreDeadLettered(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	w := new(mocks.WriterMock)
+	w.On("GetRule", cctOriginSystemID, contentType, []interface{}(nil)).Return(config.OriginSystemConfig{Collection: universalContentCollection}, nil)
+	w.On("WriteToCollection", mock.AnythingOfType("native.NativeMessage"), universalContentCollection).Return("572d0acc-3f12-4e70-8830-8092c1042a52", "", http.StatusConflict, native.ErrStaleContent)
+	dlq := new(mocks.ProducerMock)
+	dlq.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+
+	mh := NewMessageHandler(w, contentType, log)
+	mh.DeadLetterTo(dlq)
+	mh.DeadLetterStale()
+	result := mh.ProcessMessage(revisionMsg("5"))
+
+	assert.True(t, result.Stale(), "A conflict from the native writer should be reported as stale")
+	dlqMsg := dlq.Calls[0].Arguments.Get(0).(kafka.FTMessage)
+	assert.Equal(t, stageStale, dlqMsg.Headers[deadLetterStageHeader])
+}
+
+// schemaRule returns a config rule requiring a title in the body
+func schemaRule(t *testing.T, mode string) config.OriginSystemConfig {
+	schema := filepath.Join(t.TempDir(), "content.schema.json")
+	require.NoError(t, os.WriteFile(schema, []byte(`{"type": "object", "required": ["title"]}`), 0600))
+	c, err := config.ReadConfigFromReader(strings.NewReader(fmt.Sprintf(
+		`{"%s": [{"content_type": ".*", "collection": "%s", "schema": %q, "schema_mode": %q}]}`,
+		cctOriginSystemID, universalContentCollection, schema, mode)))
+	require.NoError(t, err)
+	rule, _, err := c.Route(config.Message{OriginSystemID: cctOriginSystemID, ContentType: contentType})
+	require.NoError(t, err)
+	return rule
+}
+
+func TestMessagesNotMatchingStrictSchemaAreDeadLettered(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	w := new(mocks.WriterMock)
+	w.On("GetRule", cctOriginSystemID, contentType, []interface{}(nil)).Return(schemaRule(t, config.SchemaModeStrict), nil)
+	dlq := new(mocks.ProducerMock)
+	dlq.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+
+	mh := NewMessageHandler(w, contentType, log)
+	mh.DeadLetterTo(dlq)
+	result := mh.ProcessMessage(hashedMsg("", ""))
+
+	var schemaErr *native.SchemaError
+	assert.ErrorAs(t, result.Err, &schemaErr)
+	assert.True(t, result.InvalidMessage())
+	w.AssertNotCalled(t, "WriteToCollection", mock.Anything, mock.Anything)
+	dlqMsg := dlq.Calls[0].Arguments.Get(0).(kafka.FTMessage)
+	assert.Equal(t, stageSchema, dlqMsg.Headers[deadLetterStageHeader])
+	assert.Equal(t, "body does not match schema:   : missing properties:  title ", dlqMsg.Headers[deadLetterErrorHeader])
+}
+
+func TestMessagesNotMatchingLenientSchemaAreWritten(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	w := new(mocks.WriterMock)
+	w.On("GetRule", cctOriginSystemID, contentType, []interface{}(nil)).Return(schemaRule(t, config.SchemaModeLenient), nil)
+	w.On("WriteToCollection", mock.AnythingOfType("native.NativeMessage"), universalContentCollection).Return("572d0acc-3f12-4e70-8830-8092c1042a52", "", http.StatusOK, nil)
+	invalid := metrics.MessagesSchemaInvalid.WithLabelValues(config.SchemaModeLenient, cctOriginSystemID, universalContentCollection, "")
+	before := testutil.ToFloat64(invalid)
+
+	mh := NewMessageHandler(w, contentType, log)
+	result := mh.ProcessMessage(hashedMsg("", ""))
+
+	assert.NoError(t, result.Err)
+	w.AssertExpectations(t)
+	assert.Equal(t, before+1, testutil.ToFloat64(invalid))
+}
+
+func TestMessagesAreWrittenToEveryCollection(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	w := new(mocks.WriterMock)
+	w.On("GetRule", cctOriginSystemID, contentType, []interface{}(nil)).Return(config.OriginSystemConfig{Collection: universalContentCollection, AdditionalCollections: []string{"audit"}}, nil)
+	w.On("WriteToCollection", mock.AnythingOfType("native.NativeMessage"), universalContentCollection).Return("572d0acc-3f12-4e70-8830-8092c1042a52", "", http.StatusOK, nil)
+	w.On("WriteToCollection", mock.AnythingOfType("native.NativeMessage"), "audit").Return("572d0acc-3f12-4e70-8830-8092c1042a52", "", http.StatusOK, nil)
+	p := new(mocks.ProducerMock)
+	p.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+
+	mh := NewMessageHandler(w, contentType, log)
+	mh.ForwardTo(p)
+	result := mh.ProcessMessage(hashedMsg("", ""))
+
+	assert.NoError(t, result.Err)
+	assert.Equal(t, universalContentCollection, result.Collection)
+	assert.Equal(t, []CollectionWrite{
+		{Collection: universalContentCollection, ContentUUID: "572d0acc-3f12-4e70-8830-8092c1042a52", Status: http.StatusOK},
+		{Collection: "audit", ContentUUID: "572d0acc-3f12-4e70-8830-8092c1042a52", Status: http.StatusOK},
+	}, result.Writes)
+	assert.True(t, result.Forwarded)
+	p.AssertNumberOfCalls(t, "SendMessage", 1)
+}
+
+func TestFailedWriteToOneCollectionFailsTheMessage(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	w := new(mocks.WriterMock)
+	w.On("GetRule", cctOriginSystemID, contentType, []interface{}(nil)).Return(config.OriginSystemConfig{Collection: universalContentCollection, AdditionalCollections: []string{"audit", "archive"}}, nil)
+	w.On("WriteToCollection", mock.AnythingOfType("native.NativeMessage"), universalContentCollection).Return("572d0acc-3f12-4e70-8830-8092c1042a52", "", http.StatusOK, nil)
+	w.On("WriteToCollection", mock.AnythingOfType("native.NativeMessage"), "audit").Return("572d0acc-3f12-4e70-8830-8092c1042a52", "", http.StatusServiceUnavailable, errors.New("Native writer returned non-200 code"))
+	w.On("WriteToCollection", mock.AnythingOfType("native.NativeMessage"), "archive").Return("572d0acc-3f12-4e70-8830-8092c1042a52", "", http.StatusOK, nil)
+	p := new(mocks.ProducerMock)
+	dlq := new(mocks.ProducerMock)
+	dlq.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+
+	mh := NewMessageHandler(w, contentType, log)
+	mh.ForwardTo(p)
+	mh.DeadLetterTo(dlq)
+	result := mh.ProcessMessage(hashedMsg("", ""))
+
+	assert.EqualError(t, result.Err, "audit: Native writer returned non-200 code")
+	assert.Equal(t, stageWrite, result.FailedStage)
+	w.AssertExpectations(t)
+	assert.NoError(t, result.Writes[2].Err, "The other collections should still be written")
+	p.AssertNotCalled(t, "SendMessage", mock.Anything)
+	dlq.AssertNumberOfCalls(t, "SendMessage", 1)
+}
+
+func TestUnchangedCollectionsAreNotWrittenAgain(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	w := new(mocks.WriterMock)
+	w.Parser = uuidBodyParser
+	w.On("GetRule", cctOriginSystemID, contentType, []interface{}(nil)).Return(config.OriginSystemConfig{Collection: universalContentCollection, AdditionalCollections: []string{"audit"}}, nil)
+	w.On("WriteToCollection", mock.AnythingOfType("native.NativeMessage"), "audit").Return("572d0acc-3f12-4e70-8830-8092c1042a52", "", http.StatusOK, nil)
+	store := NewLRUHashStore(10)
+	require.NoError(t, store.Store(universalContentCollection, "572d0acc-3f12-4e70-8830-8092c1042a52", "hash"))
+
+	mh := NewMessageHandler(w, contentType, log)
+	mh.SkipUnchanged(store, false)
+	result := mh.ProcessMessage(hashedMsg("hash", ""))
+
+	assert.NoError(t, result.Err)
+	assert.False(t, result.Unchanged, "The message is unchanged only if it is unchanged in every collection")
+	assert.True(t, result.Writes[0].Unchanged)
+	w.AssertNotCalled(t, "WriteToCollection", mock.Anything, universalContentCollection)
+	hash, _ := store.Hash("audit", "572d0acc-3f12-4e70-8830-8092c1042a52")
+	assert.Equal(t, "hash", hash)
+}
@@ -0,0 +1,85 @@
+package queue
+
+import (
+	"hash/fnv"
+	"sync"
+
+	"github.com/Financial-Times/kafka-client-go/v4"
+	"github.com/Financial-Times/native-ingester/native"
+)
+
+// Pipeline handles messages concurrently on a fixed set of workers.
+// Messages with the same key are always handled by the same worker, in the order they were submitted.
+type Pipeline struct {
+	handler func(kafka.FTMessage)
+	key     func(kafka.FTMessage) string
+	workers []chan kafka.FTMessage
+	wg      sync.WaitGroup
+}
+
+// NewPipeline returns a new started Pipeline with the given number of workers, each buffering up to bufferSize messages
+func NewPipeline(handler func(kafka.FTMessage), key func(kafka.FTMessage) string, workerCount int, bufferSize int) *Pipeline {
+	if workerCount < 1 {
+		workerCount = 1
+	}
+	if bufferSize < 0 {
+		bufferSize = 0
+	}
+
+	p := &Pipeline{
+		handler: handler,
+		key:     key,
+		workers: make([]chan kafka.FTMessage, workerCount),
+	}
+	for i := range p.workers {
+		p.workers[i] = make(chan kafka.FTMessage, bufferSize)
+		p.wg.Add(1)
+		go p.work(p.workers[i])
+	}
+	return p
+}
+
+func (p *Pipeline) work(messages chan kafka.FTMessage) {
+	defer p.wg.Done()
+	for msg := range messages {
+		p.handler(msg)
+	}
+}
+
+// Submit routes the message to its worker. It blocks while that worker's buffer is full.
+func (p *Pipeline) Submit(msg kafka.FTMessage) {
+	h := fnv.New32a()
+	h.Write([]byte(p.key(msg)))
+	p.workers[h.Sum32()%uint32(len(p.workers))] <- msg
+}
+
+// Close stops accepting messages and waits until all the submitted ones have been handled
+func (p *Pipeline) Close() {
+	for _, w := range p.workers {
+		close(w)
+	}
+	p.wg.Wait()
+}
+
+// ContentUUIDKey returns a key function for the Pipeline that keeps the order of messages about the same content.
+// The content UUID is found with the uuid_fields of the config rule routing the message, or the global UUID paths
+// if it is not routed. Messages without a recognisable UUID are keyed by transaction ID.
+func ContentUUIDKey(w native.Writer) func(kafka.FTMessage) string {
+	return func(msg kafka.FTMessage) string {
+		pubEvent := publicationEvent{msg}
+		writerMsg, err := pubEvent.buildNativeMessage()
+		if err != nil {
+			return pubEvent.transactionID()
+		}
+		rule, _ := w.GetRule(writerMsg)
+		parser, err := w.ParserFor(rule)
+		if err != nil {
+			return pubEvent.transactionID()
+		}
+		contentUUID, err := writerMsg.ContentUUID(parser)
+		if err != nil {
+			return pubEvent.transactionID()
+		}
+		return contentUUID
+	}
+}
@@ -0,0 +1,92 @@
+package queue
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/Financial-Times/kafka-client-go/v4"
+	"github.com/Financial-Times/native-ingester/config"
+	"github.com/Financial-Times/native-ingester/native"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipelineKeepsOrderPerUUID(t *testing.T) {
+	const uuidCount = 20
+	const messagesPerUUID = 50
+
+	uuids := make([]string, uuidCount)
+	for i := range uuids {
+		uuids[i] = fmt.Sprintf("%08d-0000-4000-8000-000000000000", i)
+	}
+
+	var mu sync.Mutex
+	handled := make(map[string][]int)
+	var running, maxRunning int32
+
+	handler := func(msg kafka.FTMessage) {
+		current := atomic.AddInt32(&running, 1)
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if current <= max || atomic.CompareAndSwapInt32(&maxRunning, max, current) {
+				break
+			}
+		}
+		time.Sleep(time.Duration(rand.Intn(200)) * time.Microsecond)
+
+		var seq int
+		fmt.Sscanf(msg.Headers["X-Request-Id"], "tid_%d", &seq)
+		contentUUID, _ := native.ExtractUUID(uuidBodyParser, msg.Body)
+		mu.Lock()
+		handled[contentUUID] = append(handled[contentUUID], seq)
+		mu.Unlock()
+		atomic.AddInt32(&running, -1)
+	}
+
+	p := NewPipeline(handler, ContentUUIDKey(keyWriter(t)), 8, 4)
+	for seq := 0; seq < messagesPerUUID; seq++ {
+		for _, u := range uuids {
+			p.Submit(kafka.FTMessage{
+				Headers: map[string]string{"X-Request-Id": fmt.Sprintf("tid_%d", seq), "Message-Timestamp": "2017-02-16T12:56:16Z"},
+				Body:    fmt.Sprintf(`{"uuid":"%s"}`, u),
+			})
+		}
+	}
+	p.Close()
+
+	assert.Len(t, handled, uuidCount, "All the UUIDs should have been handled")
+	for _, u := range uuids {
+		seqs := handled[u]
+		assert.Len(t, seqs, messagesPerUUID, "All the messages for %s should have been handled", u)
+		for i := range seqs {
+			assert.Equal(t, i, seqs[i], "Messages for %s should be handled in order", u)
+		}
+	}
+	assert.Greater(t, atomic.LoadInt32(&maxRunning), int32(1), "Messages should be handled concurrently")
+}
+
+// keyWriter returns a native writer, never called, routing the cct messages to a rule with its own uuid_fields
+func keyWriter(t *testing.T) native.Writer {
+	conf, err := config.ReadConfigFromReader(strings.NewReader(`{
+		"http://cmdb.ft.com/systems/cct": [{"content_type": ".*", "collection": "universal-content", "uuid_fields": ["id"]}]
+	}`))
+	require.NoError(t, err)
+	return native.NewWriter("http://localhost:8080", conf, uuidBodyParser, native.NoRetryPolicy, logger.NewUnstructuredLogger())
+}
+
+func TestContentUUIDKey(t *testing.T) {
+	key := ContentUUIDKey(keyWriter(t))
+	body := `{"uuid":"572d0acc-3f12-4e70-8830-8092c1042a52","id":"07ac9fad-6434-47c7-b7c4-34361a048d07"}`
+
+	assert.Equal(t, "572d0acc-3f12-4e70-8830-8092c1042a52", key(kafka.FTMessage{Headers: map[string]string{"Message-Timestamp": "2017-02-16T12:56:16Z"}, Body: body}),
+		"The global UUID paths should be used for messages that are not routed")
+	assert.Equal(t, "07ac9fad-6434-47c7-b7c4-34361a048d07", key(kafka.FTMessage{Headers: goodMsgHeaders, Body: body}),
+		"The uuid_fields of the rule routing the message should be used")
+	assert.Equal(t, "tid_test", key(badBodyMsg))
+}
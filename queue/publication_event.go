@@ -2,13 +2,26 @@ package queue
 
 import (
 	"errors"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Financial-Times/go-logger/v2"
 	"github.com/Financial-Times/kafka-client-go/v4"
 	"github.com/Financial-Times/native-ingester/native"
 )
 
+// Headers added to the forwarded messages
+const (
+	ingestCollectionHeader         = "X-Ingest-Collection"
+	ingestContentUUIDHeader        = "X-Ingest-Content-UUID"
+	ingestTimestampHeader          = "X-Ingest-Timestamp"
+	ingestAppHeader                = "X-Ingest-App"
+	ingestNativeWriterStatusHeader = "X-Ingest-Native-Writer-Status"
+)
+
+var errMissingTimestamp = errors.New("publish event does not contain timestamp")
+
 type publicationEvent struct {
 	kafka.FTMessage
 }
@@ -29,11 +42,37 @@ func (pe *publicationEvent) messageType() string {
 	return strings.TrimSpace(pe.Headers["Message-Type"])
 }
 
+// setMessageType replaces the Message-Type header of the event, without changing the headers of the consumed message
+func (pe *publicationEvent) setMessageType(messageType string) {
+	headers := make(map[string]string, len(pe.Headers)+1)
+	for k, v := range pe.Headers {
+		headers[k] = v
+	}
+	headers["Message-Type"] = messageType
+	pe.Headers = headers
+}
+
 // nativeMessage given a kafka message, extracts useful headers and body to adds them into a new NativeMessage struct.
 func (pe *publicationEvent) nativeMessage(log *logger.UPPLogger) (native.NativeMessage, error) {
+	msg, err := pe.buildNativeMessage()
+	if err != nil {
+		return native.NativeMessage{}, err
+	}
+	log.WithTransactionID(pe.transactionID()).
+		WithField("Content-Type", msg.ContentType()).
+		WithField("Origin-System-Id", msg.OriginSystemID()).
+		WithField("X-Content-Revision", msg.ContentRevision()).
+		WithField("X-Schema-Version", msg.SchemaVersion()).
+		Infof("Constructed new NativeMessage")
+
+	return msg, nil
+}
+
+// buildNativeMessage builds the NativeMessage of nativeMessage, without logging it
+func (pe *publicationEvent) buildNativeMessage() (native.NativeMessage, error) {
 	timestamp, found := pe.Headers["Message-Timestamp"]
 	if !found {
-		return native.NativeMessage{}, errors.New("publish event does not contain timestamp")
+		return native.NativeMessage{}, errMissingTimestamp
 	}
 
 	msg, err := native.NewNativeMessage(pe.Body, timestamp, pe.transactionID(), pe.messageType())
@@ -42,6 +81,8 @@ func (pe *publicationEvent) nativeMessage(log *logger.UPPLogger) (native.NativeM
 		return native.NativeMessage{}, err
 	}
 
+	msg.SetMessageHeaders(pe.Headers)
+
 	nativeHash, found := pe.Headers["Native-Hash"]
 	if found {
 		msg.AddHashHeader(nativeHash)
@@ -63,19 +104,31 @@ func (pe *publicationEvent) nativeMessage(log *logger.UPPLogger) (native.NativeM
 	if found {
 		msg.AddContentRevision(contentRevision)
 	}
-	log.WithTransactionID(pe.transactionID()).
-		WithField("Content-Type", msg.ContentType()).
-		WithField("Origin-System-Id", msg.OriginSystemID()).
-		WithField("X-Content-Revision", msg.ContentRevision()).
-		WithField("X-Schema-Version", msg.SchemaVersion()).
-		Infof("Constructed new NativeMessage")
-
 	return msg, nil
 }
 
-func (pe *publicationEvent) producerMsg() kafka.FTMessage {
+// producerMsg builds the message forwarded after writing in the native store: the consumed message with additional headers
+// describing its ingestion. The headers whose value is unknown, like the native writer status of an unchanged message, are not added.
+func (pe *publicationEvent) producerMsg(result Result, appName string, ingestedAt time.Time) kafka.FTMessage {
+	headers := make(map[string]string, len(pe.Headers)+5)
+	for k, v := range pe.Headers {
+		headers[k] = v
+	}
+
+	headers[ingestCollectionHeader] = result.Collection
+	headers[ingestTimestampHeader] = ingestedAt.UTC().Format(time.RFC3339Nano)
+	if result.ContentUUID != "" {
+		headers[ingestContentUUIDHeader] = result.ContentUUID
+	}
+	if appName != "" {
+		headers[ingestAppHeader] = appName
+	}
+	if len(result.Writes) > 0 && result.Writes[0].Status != 0 {
+		headers[ingestNativeWriterStatusHeader] = strconv.Itoa(result.Writes[0].Status)
+	}
+
 	return kafka.FTMessage{
-		Headers: pe.Headers,
+		Headers: headers,
 		Body:    pe.Body,
 	}
 }
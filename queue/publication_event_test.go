@@ -1,7 +1,9 @@
 package queue
 
 import (
+	"net/http"
 	"testing"
+	"time"
 
 	"github.com/Financial-Times/go-logger/v2"
 	"github.com/Financial-Times/kafka-client-go/v4"
@@ -73,8 +75,37 @@ func TestGetCNativeNativeMessageFailBecauseMissingTimstamp(t *testing.T) {
 
 func TestGetProducerMessage(t *testing.T) {
 	pe := publicationEvent{aMsg}
-	actualProducerMsg := pe.producerMsg()
+	result := Result{
+		Collection:  "universal-content",
+		ContentUUID: "572d0acc-3f12-4e70-8830-8092c1042a52",
+		Writes:      []CollectionWrite{{Collection: "universal-content", ContentUUID: "572d0acc-3f12-4e70-8830-8092c1042a52", Status: http.StatusCreated}},
+	}
+	ingestedAt := time.Date(2017, 2, 16, 12, 56, 17, 500000000, time.FixedZone("CET", 3600))
+	actualProducerMsg := pe.producerMsg(result, "native-ingester", ingestedAt)
 
 	assert.Equal(t, aMsg.Body, actualProducerMsg.Body, "It should have the same body of the consumer message")
-	assert.Equal(t, aMsg.Headers, actualProducerMsg.Headers, "It should have the same headers of the consumer message")
+	for header, value := range aMsg.Headers {
+		assert.Equal(t, value, actualProducerMsg.Headers[header], "It should have the headers of the consumer message")
+	}
+	assert.Equal(t, "universal-content", actualProducerMsg.Headers[ingestCollectionHeader])
+	assert.Equal(t, "572d0acc-3f12-4e70-8830-8092c1042a52", actualProducerMsg.Headers[ingestContentUUIDHeader])
+	assert.Equal(t, "2017-02-16T11:56:17.5Z", actualProducerMsg.Headers[ingestTimestampHeader])
+	assert.Equal(t, "native-ingester", actualProducerMsg.Headers[ingestAppHeader])
+	assert.Equal(t, "201", actualProducerMsg.Headers[ingestNativeWriterStatusHeader])
+	assert.NotContains(t, aMsg.Headers, ingestCollectionHeader, "It should not change the headers of the consumer message")
+}
+
+func TestGetProducerMessageOfUnchangedContent(t *testing.T) {
+	pe := publicationEvent{aMsg}
+	result := Result{
+		Collection: "universal-content",
+		Writes:     []CollectionWrite{{Collection: "universal-content", Unchanged: true}},
+	}
+	actualProducerMsg := pe.producerMsg(result, "", time.Now())
+
+	assert.Equal(t, "universal-content", actualProducerMsg.Headers[ingestCollectionHeader])
+	assert.NotEmpty(t, actualProducerMsg.Headers[ingestTimestampHeader])
+	assert.NotContains(t, actualProducerMsg.Headers, ingestContentUUIDHeader, "Unknown values should not be sent")
+	assert.NotContains(t, actualProducerMsg.Headers, ingestAppHeader, "Unknown values should not be sent")
+	assert.NotContains(t, actualProducerMsg.Headers, ingestNativeWriterStatusHeader, "Unknown values should not be sent")
 }
@@ -0,0 +1,177 @@
+package queue
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/Financial-Times/kafka-client-go/v4"
+)
+
+const (
+	recordFilePrefix     = "messages-"
+	recordFileTimeFormat = "20060102T150405.000000000Z"
+	recordFileExtension  = ".jsonl"
+	gzipExtension        = ".gz"
+)
+
+// RecorderConfig configures where and how the consumed messages are archived
+type RecorderConfig struct {
+	// Dir is the directory the archive files are written to
+	Dir string
+	// MaxSize is the size in bytes (before compression) after which a new file is started, 0 for no limit
+	MaxSize int64
+	// RotationInterval is the time after which a new file is started, 0 for no limit
+	RotationInterval time.Duration
+	// Compress gzips the archive files
+	Compress bool
+	// Retention is how long rotated files are kept, 0 to keep them forever
+	Retention time.Duration
+}
+
+// Recorder archives messages in rotated JSONL files, in the format read by Replay
+type Recorder struct {
+	config RecorderConfig
+	mu     sync.Mutex
+	file   *os.File
+	gz     *gzip.Writer
+	out    io.Writer
+	size   int64
+	opened time.Time
+	now    func() time.Time
+	logger *logger.UPPLogger
+}
+
+// NewRecorder returns a new instance of a Recorder, creating its directory if needed
+func NewRecorder(config RecorderConfig, logger *logger.UPPLogger) (*Recorder, error) {
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating record directory: %w", err)
+	}
+	return &Recorder{config: config, now: time.Now, logger: logger}, nil
+}
+
+// Record appends the message to the current archive file, starting a new one when it is due
+func (r *Recorder) Record(msg kafka.FTMessage) error {
+	line, err := json.Marshal(archivedMessage{Headers: msg.Headers, Body: msg.Body, Topic: msg.Topic})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.rotationDue() {
+		if err := r.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := r.out.Write(line)
+	r.size += int64(n)
+	if err != nil || r.gz == nil {
+		return err
+	}
+	// flushing every message keeps the archive readable up to the last message if the service stops abruptly
+	return r.gz.Flush()
+}
+
+// Close flushes and closes the current archive file
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.closeFile()
+}
+
+func (r *Recorder) rotationDue() bool {
+	if r.file == nil {
+		return true
+	}
+	if r.config.MaxSize > 0 && r.size >= r.config.MaxSize {
+		return true
+	}
+	return r.config.RotationInterval > 0 && r.now().Sub(r.opened) >= r.config.RotationInterval
+}
+
+func (r *Recorder) rotate() error {
+	if err := r.closeFile(); err != nil {
+		r.logger.WithError(err).Error("Failed to close record file")
+	}
+
+	r.opened = r.now()
+	name := recordFilePrefix + r.opened.UTC().Format(recordFileTimeFormat) + recordFileExtension
+	if r.config.Compress {
+		name += gzipExtension
+	}
+	file, err := os.OpenFile(filepath.Join(r.config.Dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening record file: %w", err)
+	}
+	r.file = file
+	r.out = file
+	if r.config.Compress {
+		r.gz = gzip.NewWriter(file)
+		r.out = r.gz
+	}
+	r.size = 0
+	r.logger.WithField("file", file.Name()).Info("Recording consumed messages to new file")
+
+	r.removeExpired()
+	return nil
+}
+
+func (r *Recorder) closeFile() error {
+	if r.file == nil {
+		return nil
+	}
+	var gzErr error
+	if r.gz != nil {
+		gzErr = r.gz.Close()
+		r.gz = nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	r.out = nil
+	if gzErr != nil {
+		return gzErr
+	}
+	return err
+}
+
+// removeExpired deletes the archive files last written before the retention period
+func (r *Recorder) removeExpired() {
+	if r.config.Retention <= 0 {
+		return
+	}
+	entries, err := os.ReadDir(r.config.Dir)
+	if err != nil {
+		r.logger.WithError(err).Error("Failed to list record files")
+		return
+	}
+	expiry := r.now().Add(-r.config.Retention)
+	for _, entry := range entries {
+		if !isRecordFile(entry.Name()) || filepath.Join(r.config.Dir, entry.Name()) == r.file.Name() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.ModTime().Before(expiry) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(r.config.Dir, entry.Name())); err != nil {
+			r.logger.WithError(err).WithField("file", entry.Name()).Error("Failed to remove expired record file")
+			continue
+		}
+		r.logger.WithField("file", entry.Name()).Info("Removed expired record file")
+	}
+}
+
+func isRecordFile(name string) bool {
+	return strings.HasPrefix(name, recordFilePrefix) &&
+		(strings.HasSuffix(name, recordFileExtension) || strings.HasSuffix(name, recordFileExtension+gzipExtension))
+}
@@ -0,0 +1,142 @@
+package queue
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/Financial-Times/kafka-client-go/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var recordedMsg = kafka.FTMessage{
+	Headers: map[string]string{
+		"X-Request-Id":     "tid_test",
+		"Origin-System-Id": "http://cmdb.ft.com/systems/cct",
+		"Content-Type":     "application/json",
+	},
+	Body:  `{"uuid":"572d0acc-3f12-4e70-8830-8092c1042a52"}`,
+	Topic: "PreNativeCmsPublicationEvents",
+}
+
+func recordFiles(t *testing.T, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, recordFilePrefix+"*"))
+	require.NoError(t, err)
+	return files
+}
+
+func replayedMessages(t *testing.T, r io.Reader) []kafka.FTMessage {
+	var msgs []kafka.FTMessage
+	_, err := Replay(r, func(msg kafka.FTMessage) Result {
+		msgs = append(msgs, msg)
+		return Result{}
+	}, ReplayOptions{}, logger.NewUnstructuredLogger())
+	require.NoError(t, err)
+	return msgs
+}
+
+func TestRecordedMessagesCanBeReplayed(t *testing.T) {
+	dir := t.TempDir()
+	r, err := NewRecorder(RecorderConfig{Dir: dir}, logger.NewUnstructuredLogger())
+	require.NoError(t, err)
+
+	require.NoError(t, r.Record(recordedMsg))
+	require.NoError(t, r.Record(recordedMsg))
+	require.NoError(t, r.Close())
+
+	files := recordFiles(t, dir)
+	require.Len(t, files, 1)
+	assert.Regexp(t, `messages-\d{8}T\d{6}\.\d{9}Z\.jsonl$`, files[0])
+
+	f, err := os.Open(files[0])
+	require.NoError(t, err)
+	defer f.Close()
+	assert.Equal(t, []kafka.FTMessage{recordedMsg, recordedMsg}, replayedMessages(t, f))
+}
+
+func TestRecordCompressed(t *testing.T) {
+	dir := t.TempDir()
+	r, err := NewRecorder(RecorderConfig{Dir: dir, Compress: true}, logger.NewUnstructuredLogger())
+	require.NoError(t, err)
+	require.NoError(t, r.Record(recordedMsg))
+
+	files := recordFiles(t, dir)
+	require.Len(t, files, 1)
+	assert.Regexp(t, `\.jsonl\.gz$`, files[0])
+
+	f, err := os.Open(files[0])
+	require.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	var msgs []kafka.FTMessage
+	_, err = Replay(gz, func(msg kafka.FTMessage) Result {
+		msgs = append(msgs, msg)
+		return Result{}
+	}, ReplayOptions{}, logger.NewUnstructuredLogger())
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF, "The gzip stream is only complete once the file is closed")
+	assert.Equal(t, []kafka.FTMessage{recordedMsg}, msgs, "Recorded messages should be readable before the file is closed")
+
+	require.NoError(t, r.Close())
+}
+
+func TestRecorderRotatesBySize(t *testing.T) {
+	dir := t.TempDir()
+	r, err := NewRecorder(RecorderConfig{Dir: dir, MaxSize: 10}, logger.NewUnstructuredLogger())
+	require.NoError(t, err)
+	defer r.Close()
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, r.Record(recordedMsg))
+	}
+
+	assert.Len(t, recordFiles(t, dir), 3)
+}
+
+func TestRecorderRotatesByTime(t *testing.T) {
+	dir := t.TempDir()
+	r, err := NewRecorder(RecorderConfig{Dir: dir, RotationInterval: time.Hour}, logger.NewUnstructuredLogger())
+	require.NoError(t, err)
+	defer r.Close()
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+
+	require.NoError(t, r.Record(recordedMsg))
+	now = now.Add(59 * time.Minute)
+	require.NoError(t, r.Record(recordedMsg))
+	now = now.Add(time.Minute)
+	require.NoError(t, r.Record(recordedMsg))
+
+	assert.Equal(t, []string{
+		filepath.Join(dir, "messages-20261016T120000.000000000Z.jsonl"),
+		filepath.Join(dir, "messages-20261016T130000.000000000Z.jsonl"),
+	}, recordFiles(t, dir))
+}
+
+func TestRecorderRemovesExpiredFiles(t *testing.T) {
+	dir := t.TempDir()
+	expired := filepath.Join(dir, "messages-20261001T120000.000000000Z.jsonl.gz")
+	recent := filepath.Join(dir, "messages-20261016T110000.000000000Z.jsonl")
+	other := filepath.Join(dir, "notes.txt")
+	for _, f := range []string{expired, recent, other} {
+		require.NoError(t, os.WriteFile(f, []byte("{}\n"), 0o644))
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	require.NoError(t, os.Chtimes(expired, old, old))
+	require.NoError(t, os.Chtimes(other, old, old))
+
+	r, err := NewRecorder(RecorderConfig{Dir: dir, Retention: 24 * time.Hour}, logger.NewUnstructuredLogger())
+	require.NoError(t, err)
+	defer r.Close()
+	require.NoError(t, r.Record(recordedMsg))
+
+	assert.NoFileExists(t, expired)
+	assert.FileExists(t, recent)
+	assert.FileExists(t, other, "Only record files should be removed")
+	assert.Len(t, recordFiles(t, dir), 2)
+}
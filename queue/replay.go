@@ -0,0 +1,119 @@
+package queue
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/Financial-Times/kafka-client-go/v4"
+)
+
+// maxArchivedMessageSize is the longest line accepted in a message archive
+const maxArchivedMessageSize = 16 * 1024 * 1024
+
+// archivedMessage is the JSON representation of a message, one per line, in a message archive
+type archivedMessage struct {
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+	Topic   string            `json:"topic,omitempty"`
+}
+
+// ReplayOptions selects the messages of an archive to replay and how fast
+type ReplayOptions struct {
+	// Start is the offset (line number, starting at 0) of the first message to replay
+	Start int
+	// End is the offset of the first message not to replay, 0 to replay until the end of the archive
+	End int
+	// Rate is the maximum number of messages replayed per second, 0 for no limit
+	Rate float64
+	// OriginSystems restricts the replay to these origin systems, if not empty
+	OriginSystems []string
+	// ContentType restricts the replay to the content types it matches, if not nil
+	ContentType *regexp.Regexp
+}
+
+// ReplaySummary reports what happened to the messages of a replayed archive
+type ReplaySummary struct {
+	Read      int
+	Malformed int
+	Filtered  int
+	Replayed  int
+	Ingested  int
+	Unchanged int
+	Skipped   int
+	Failed    map[string]int
+	Duration  time.Duration
+}
+
+// Replay reads an archive of messages, one JSON object with headers and body per line,
+// and processes the selected ones in order with the given function
+func Replay(r io.Reader, process func(kafka.FTMessage) Result, opts ReplayOptions, log *logger.UPPLogger) (ReplaySummary, error) {
+	summary := ReplaySummary{Failed: make(map[string]int)}
+	start := time.Now()
+
+	var throttle <-chan time.Time
+	if opts.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / opts.Rate))
+		defer ticker.Stop()
+		throttle = ticker.C
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxArchivedMessageSize)
+	for offset := 0; scanner.Scan(); offset++ {
+		if offset < opts.Start {
+			continue
+		}
+		if opts.End > 0 && offset >= opts.End {
+			break
+		}
+		summary.Read++
+
+		var archived archivedMessage
+		if err := json.Unmarshal(scanner.Bytes(), &archived); err != nil {
+			log.WithError(err).WithField("offset", offset).Error("Malformed message in archive. Ignoring it.")
+			summary.Malformed++
+			continue
+		}
+		msg := kafka.NewFTMessage(archived.Headers, archived.Body)
+		msg.Topic = archived.Topic
+		if !opts.selects(msg) {
+			summary.Filtered++
+			continue
+		}
+
+		if throttle != nil {
+			<-throttle
+		}
+		result := process(msg)
+		summary.Replayed++
+		switch {
+		case result.Skipped:
+			summary.Skipped++
+		case result.Err != nil:
+			summary.Failed[result.FailedStage]++
+		case result.Unchanged:
+			summary.Unchanged++
+		default:
+			summary.Ingested++
+		}
+	}
+	summary.Duration = time.Since(start)
+	if err := scanner.Err(); err != nil {
+		return summary, fmt.Errorf("reading archive: %w", err)
+	}
+	return summary, nil
+}
+
+func (opts ReplayOptions) selects(msg kafka.FTMessage) bool {
+	pubEvent := publicationEvent{msg}
+	if len(opts.OriginSystems) > 0 && !slices.Contains(opts.OriginSystems, pubEvent.originSystemID()) {
+		return false
+	}
+	return opts.ContentType == nil || opts.ContentType.MatchString(pubEvent.contentType())
+}
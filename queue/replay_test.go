@@ -0,0 +1,85 @@
+package queue
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/Financial-Times/kafka-client-go/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+const testArchive = `{"headers":{"X-Request-Id":"tid_0","Origin-System-Id":"http://cmdb.ft.com/systems/cct","Content-Type":"application/vnd.ft-upp-article+json"},"body":"{}"}
+{"headers":{"X-Request-Id":"tid_1","Origin-System-Id":"http://cmdb.ft.com/systems/spark","Content-Type":"application/vnd.ft-upp-article+json"},"body":"{}"}
+not a message
+{"headers":{"X-Request-Id":"tid_3","Origin-System-Id":"http://cmdb.ft.com/systems/cct","Content-Type":"application/vnd.ft-upp-list+json"},"body":"{}"}
+{"headers":{"X-Request-Id":"tid_4","Origin-System-Id":"http://cmdb.ft.com/systems/cct","Content-Type":"application/vnd.ft-upp-article+json"},"body":"{}"}
+`
+
+type processRecorder struct {
+	tids    []string
+	results map[string]Result
+}
+
+func (p *processRecorder) process(msg kafka.FTMessage) Result {
+	tid := msg.Headers["X-Request-Id"]
+	p.tids = append(p.tids, tid)
+	return p.results[tid]
+}
+
+func TestReplayAllMessages(t *testing.T) {
+	p := &processRecorder{results: map[string]Result{
+		"tid_1": {Skipped: true},
+		"tid_3": {FailedStage: stageWrite, Err: errors.New("Native writer returned non-200 code")},
+	}}
+
+	summary, err := Replay(strings.NewReader(testArchive), p.process, ReplayOptions{}, logger.NewUnstructuredLogger())
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tid_0", "tid_1", "tid_3", "tid_4"}, p.tids)
+	assert.Equal(t, 5, summary.Read)
+	assert.Equal(t, 1, summary.Malformed)
+	assert.Equal(t, 0, summary.Filtered)
+	assert.Equal(t, 4, summary.Replayed)
+	assert.Equal(t, 2, summary.Ingested)
+	assert.Equal(t, 1, summary.Skipped)
+	assert.Equal(t, map[string]int{stageWrite: 1}, summary.Failed)
+}
+
+func TestReplayOffsets(t *testing.T) {
+	p := &processRecorder{}
+
+	summary, err := Replay(strings.NewReader(testArchive), p.process, ReplayOptions{Start: 1, End: 4}, logger.NewUnstructuredLogger())
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tid_1", "tid_3"}, p.tids)
+	assert.Equal(t, 3, summary.Read)
+}
+
+func TestReplayFilters(t *testing.T) {
+	p := &processRecorder{}
+	opts := ReplayOptions{
+		OriginSystems: []string{"http://cmdb.ft.com/systems/cct"},
+		ContentType:   regexp.MustCompile(`article`),
+	}
+
+	summary, err := Replay(strings.NewReader(testArchive), p.process, opts, logger.NewUnstructuredLogger())
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tid_0", "tid_4"}, p.tids)
+	assert.Equal(t, 2, summary.Filtered)
+	assert.Equal(t, 2, summary.Replayed)
+}
+
+func TestReplayRateLimit(t *testing.T) {
+	p := &processRecorder{}
+
+	summary, err := Replay(strings.NewReader(testArchive), p.process, ReplayOptions{Rate: 50}, logger.NewUnstructuredLogger())
+
+	assert.NoError(t, err)
+	assert.Equal(t, 4, summary.Replayed)
+	assert.GreaterOrEqual(t, summary.Duration, 80*time.Millisecond)
+}
@@ -0,0 +1,47 @@
+package queue
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/Financial-Times/native-ingester/native"
+)
+
+// RevisionGuard keeps the highest X-Content-Revision written for each content UUID,
+// so that a message with a lower revision does not overwrite newer content
+type RevisionGuard struct {
+	mu        sync.Mutex
+	revisions *lru[int64]
+}
+
+// NewRevisionGuard returns a RevisionGuard remembering the revisions of at most size content UUIDs
+func NewRevisionGuard(size int) *RevisionGuard {
+	return &RevisionGuard{revisions: newLRU[int64](size)}
+}
+
+// check returns an error wrapping native.ErrStaleContent if a higher revision of the content was already written
+func (g *RevisionGuard) check(contentUUID string, revision int64) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	highest, found := g.revisions.get(contentUUID)
+	if found && revision < highest {
+		return fmt.Errorf("%w: revision %d is lower than revision %d already written", native.ErrStaleContent, revision, highest)
+	}
+	return nil
+}
+
+// record remembers the revision written, if it is the highest one
+func (g *RevisionGuard) record(contentUUID string, revision int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if highest, found := g.revisions.get(contentUUID); !found || revision > highest {
+		g.revisions.put(contentUUID, revision)
+	}
+}
+
+// parseRevision reads the X-Content-Revision of a message, which is only guarded if it is an integer
+func parseRevision(writerMsg native.NativeMessage) (int64, bool) {
+	revision, err := strconv.ParseInt(writerMsg.ContentRevision(), 10, 64)
+	return revision, err == nil
+}
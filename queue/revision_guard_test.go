@@ -0,0 +1,23 @@
+package queue
+
+import (
+	"testing"
+
+	"github.com/Financial-Times/native-ingester/native"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRevisionGuard(t *testing.T) {
+	g := NewRevisionGuard(10)
+
+	assert.NoError(t, g.check("uuid-1", 5), "Unknown content should not be stale")
+	g.record("uuid-1", 5)
+	g.record("uuid-1", 3)
+
+	assert.NoError(t, g.check("uuid-1", 5), "The same revision should not be stale")
+	assert.NoError(t, g.check("uuid-1", 6))
+	assert.NoError(t, g.check("uuid-2", 1))
+	err := g.check("uuid-1", 4)
+	assert.ErrorIs(t, err, native.ErrStaleContent)
+	assert.EqualError(t, err, "stale content: revision 4 is lower than revision 5 already written")
+}
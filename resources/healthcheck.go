@@ -16,6 +16,7 @@ type HealthCheck struct {
 	writer     native.Writer
 	consumer   kafkaConsumer
 	producer   kafkaProducer
+	config     configReloader
 	panicGuide string
 	logger     *logger.UPPLogger
 }
@@ -29,12 +30,17 @@ type kafkaProducer interface {
 	ConnectivityCheck() error
 }
 
+type configReloader interface {
+	ReloadCheck() error
+}
+
 // NewHealthCheck return a new instance of a native ingester HealthCheck
-func NewHealthCheck(consumer kafkaConsumer, producer kafkaProducer, writer native.Writer, panicGuide string, logger *logger.UPPLogger) *HealthCheck {
+func NewHealthCheck(consumer kafkaConsumer, producer kafkaProducer, writer native.Writer, config configReloader, panicGuide string, logger *logger.UPPLogger) *HealthCheck {
 	return &HealthCheck{
 		writer:     writer,
 		consumer:   consumer,
 		producer:   producer,
+		config:     config,
 		panicGuide: panicGuide,
 		logger:     logger,
 	}
@@ -88,6 +94,18 @@ func (hc *HealthCheck) nativeWriterCheck() fthealth.Check {
 	}
 }
 
+func (hc *HealthCheck) configReloadCheck() fthealth.Check {
+	return fthealth.Check{
+		ID:               "config-reload",
+		BusinessImpact:   "Changes to the collection routing configuration are not applied. The previous configuration is still in use.",
+		Name:             "ConfigurationReloaded",
+		PanicGuide:       hc.panicGuide,
+		Severity:         3,
+		TechnicalSummary: "The configuration file could not be reloaded because it is unreadable or invalid",
+		Checker:          check(hc.config.ReloadCheck, hc.logger, "Configuration reload"),
+	}
+}
+
 func check(fn func() error, logger *logger.UPPLogger, component string) func() (string, error) {
 	return func() (string, error) {
 		if err := fn(); err != nil {
@@ -105,6 +123,9 @@ func (hc *HealthCheck) Handler() func(w http.ResponseWriter, req *http.Request)
 	if hc.producer != nil {
 		checks = append(checks, hc.producerQueueCheck())
 	}
+	if hc.config != nil {
+		checks = append(checks, hc.configReloadCheck())
+	}
 
 	healthCheck := fthealth.TimedHealthCheck{
 		HealthCheck: fthealth.HealthCheck{
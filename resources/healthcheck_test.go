@@ -32,7 +32,7 @@ func TestNewHealthCheckWithoutProducer(t *testing.T) {
 	require.NoError(t, err)
 
 	nw := new(mocks.WriterMock)
-	hc := NewHealthCheck(consumer, nil, nw, "http://test-panic-guide.com", log)
+	hc := NewHealthCheck(consumer, nil, nw, nil, "http://test-panic-guide.com", log)
 
 	assert.Nil(t, hc.producer)
 	assert.NotNil(t, hc.consumer)
@@ -69,7 +69,7 @@ func TestNewHealthCheckWithProducer(t *testing.T) {
 	require.NoError(t, err)
 
 	nw := new(mocks.WriterMock)
-	hc := NewHealthCheck(consumer, producer, nw, "http://test-panic-guide.com", log)
+	hc := NewHealthCheck(consumer, producer, nw, nil, "http://test-panic-guide.com", log)
 
 	assert.NotNil(t, hc.producer)
 	assert.NotNil(t, hc.consumer)
@@ -417,3 +417,30 @@ func TestUnhappyGTGCheck(t *testing.T) {
 	assert.False(t, status.GoodToGo)
 	assert.Equal(t, "I'm not fat, I'm big-boned.", status.Message)
 }
+
+func TestConfigReloadHealthCheck(t *testing.T) {
+	c := new(mocks.ConsumerMock)
+	c.On("ConnectivityCheck").Return(nil)
+	c.On("MonitorCheck").Return(nil)
+	nw := new(mocks.WriterMock)
+	nw.On("ConnectivityCheck").Return("I'm a happy writer", nil)
+	cfg := new(mocks.ConfigReloaderMock)
+	cfg.On("ReloadCheck").Return(errors.New("contentType value is mandatory")).Once()
+	cfg.On("ReloadCheck").Return(nil)
+	hc := HealthCheck{
+		consumer: c,
+		writer:   nw,
+		config:   cfg,
+		logger:   logger.NewUnstructuredLogger(),
+	}
+
+	req := httptest.NewRequest("GET", "http://example.com/__health", nil)
+	w := httptest.NewRecorder()
+	hc.Handler()(w, req)
+	assert.Contains(t, w.Body.String(), `"name":"ConfigurationReloaded","ok":false`, "Configuration reload healthcheck should be unhappy")
+	assert.Contains(t, w.Body.String(), "contentType value is mandatory", "The reload error should be reported")
+
+	w = httptest.NewRecorder()
+	hc.Handler()(w, req)
+	assert.Contains(t, w.Body.String(), `"name":"ConfigurationReloaded","ok":true`, "Configuration reload healthcheck should be happy")
+}
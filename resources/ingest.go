@@ -0,0 +1,136 @@
+package resources
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/Financial-Times/kafka-client-go/v4"
+	"github.com/Financial-Times/native-ingester/config"
+	"github.com/Financial-Times/native-ingester/queue"
+	"github.com/google/uuid"
+)
+
+// ingestHeaders are the HTTP request headers copied into the ingested message, the same ones read from Kafka messages
+var ingestHeaders = []string{
+	"X-Request-Id",
+	"Origin-System-Id",
+	"Content-Type",
+	"Message-Timestamp",
+	"Message-Type",
+	"Native-Hash",
+	"X-Schema-Version",
+	"X-Content-Revision",
+}
+
+// maxIngestBodySize is the size limit of the ingested message bodies, in bytes
+const maxIngestBodySize = 10 << 20
+
+type messageProcessor interface {
+	ProcessMessage(msg kafka.FTMessage) queue.Result
+}
+
+// IngestHandler ingests messages received over HTTP, bypassing the consumer queue
+type IngestHandler struct {
+	processor messageProcessor
+	config    config.Provider
+	logger    *logger.UPPLogger
+}
+
+type ingestResponse struct {
+	Collection  string `json:"collection,omitempty"`
+	ContentUUID string `json:"contentUUID,omitempty"`
+	Forwarded   bool   `json:"forwarded"`
+	Unchanged   bool   `json:"unchanged,omitempty"`
+	DryRun      bool   `json:"dryRun,omitempty"`
+	FailedStage string `json:"failedStage,omitempty"`
+	Error       string `json:"error,omitempty"`
+	// Writes reports the write to each collection, for the messages written to several ones
+	Writes []collectionWriteResponse `json:"writes,omitempty"`
+}
+
+type collectionWriteResponse struct {
+	Collection  string `json:"collection"`
+	ContentUUID string `json:"contentUUID,omitempty"`
+	Unchanged   bool   `json:"unchanged,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// NewIngestHandler returns a new instance of an IngestHandler.
+// The headers the rules of the configuration match on are copied into the ingested messages, in addition to the ingestHeaders.
+func NewIngestHandler(processor messageProcessor, config config.Provider, logger *logger.UPPLogger) *IngestHandler {
+	return &IngestHandler{processor: processor, config: config, logger: logger}
+}
+
+// Ingest handles the POST request with the message body and headers synchronously, as if it was consumed from the queue
+func (h *IngestHandler) Ingest(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIngestBodySize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeIngestResponse(w, http.StatusRequestEntityTooLarge, ingestResponse{Error: fmt.Sprintf("request body exceeds %d bytes", tooLarge.Limit)})
+		return
+	}
+	if err != nil {
+		writeIngestResponse(w, http.StatusBadRequest, ingestResponse{Error: err.Error()})
+		return
+	}
+
+	headers := make(map[string]string)
+	for _, header := range append(ingestHeaders, h.config.Current().HeaderNames()...) {
+		if value := r.Header.Get(header); value != "" {
+			headers[header] = value
+		}
+	}
+	if headers["X-Request-Id"] == "" {
+		headers["X-Request-Id"] = "tid_ingest_" + uuid.NewString()
+	}
+	h.logger.WithTransactionID(headers["X-Request-Id"]).Info("Received message to ingest over HTTP")
+
+	result := h.processor.ProcessMessage(kafka.NewFTMessage(headers, string(body)))
+
+	resp := ingestResponse{
+		Collection:  result.Collection,
+		ContentUUID: result.ContentUUID,
+		Forwarded:   result.Forwarded,
+		Unchanged:   result.Unchanged,
+		DryRun:      result.DryRun,
+		FailedStage: result.FailedStage,
+	}
+	if result.Err != nil {
+		resp.Error = result.Err.Error()
+	}
+	if len(result.Writes) > 1 {
+		for _, write := range result.Writes {
+			writeResp := collectionWriteResponse{Collection: write.Collection, ContentUUID: write.ContentUUID, Unchanged: write.Unchanged}
+			if write.Err != nil {
+				writeResp.Error = write.Err.Error()
+			}
+			resp.Writes = append(resp.Writes, writeResp)
+		}
+	}
+	writeIngestResponse(w, ingestStatusCode(result), resp)
+}
+
+func ingestStatusCode(result queue.Result) int {
+	switch {
+	case result.Skipped:
+		return http.StatusUnprocessableEntity
+	case result.Err == nil:
+		return http.StatusOK
+	case result.InvalidMessage():
+		return http.StatusBadRequest
+	case result.Stale():
+		return http.StatusConflict
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+func writeIngestResponse(w http.ResponseWriter, status int, resp ingestResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,191 @@
+package resources
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/Financial-Times/kafka-client-go/v4"
+	"github.com/Financial-Times/native-ingester/config"
+	"github.com/Financial-Times/native-ingester/mocks"
+	"github.com/Financial-Times/native-ingester/native"
+	"github.com/Financial-Times/native-ingester/queue"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	cctOriginSystemID = "http://cmdb.ft.com/systems/cct"
+	aContentType      = "application/json; version=1.0"
+	aUUID             = "572d0acc-3f12-4e70-8830-8092c1042a52"
+)
+
+func newIngestRequest(body string, headers map[string]string) *http.Request {
+	req := httptest.NewRequest("POST", "http://example.com/ingest", strings.NewReader(body))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	return req
+}
+
+var ingestRequestHeaders = map[string]string{
+	"X-Request-Id":      "tid_test",
+	"Origin-System-Id":  cctOriginSystemID,
+	"Content-Type":      aContentType,
+	"Message-Timestamp": "2017-02-16T12:56:16Z",
+	"Native-Hash":       "27f79e6d884acdd642d1758c4fd30d43074f8384d552d1ebb1959345",
+	"X-Custom-Header":   "copied",
+	"Authorization":     "Bearer secret",
+}
+
+// ingestConfig returns a configuration with a rule matching on the X-Custom-Header header
+func ingestConfig(t *testing.T) *config.Configuration {
+	conf, err := config.ReadConfigFromReader(strings.NewReader(`{
+		"http://cmdb.ft.com/systems/cct": [{"content_type": ".*", "collection": "universal-content", "headers": {"X-Custom-Header": ".+"}}]
+	}`))
+	require.NoError(t, err)
+	return conf
+}
+
+func decodeIngestResponse(t *testing.T, w *httptest.ResponseRecorder) map[string]interface{} {
+	var resp map[string]interface{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	return resp
+}
+
+func TestIngestSuccessfully(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	w := new(mocks.WriterMock)
+	w.On("GetRule", cctOriginSystemID, aContentType, []interface{}(nil)).Return(config.OriginSystemConfig{Collection: "universal-content"}, nil)
+	w.On("WriteToCollection", mock.AnythingOfType("native.NativeMessage"), "universal-content").Return(aUUID, "", http.StatusOK, nil)
+	p := new(mocks.ProducerMock)
+	p.On("SendMessage", mock.AnythingOfType("kafka.FTMessage")).Return(nil)
+
+	mh := queue.NewMessageHandler(w, "Content", log)
+	mh.ForwardTo(p)
+	h := NewIngestHandler(mh, ingestConfig(t), log)
+
+	rec := httptest.NewRecorder()
+	h.Ingest(rec, newIngestRequest(`{"uuid":"`+aUUID+`"}`, ingestRequestHeaders))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, map[string]interface{}{
+		"collection":  "universal-content",
+		"contentUUID": aUUID,
+		"forwarded":   true,
+	}, decodeIngestResponse(t, rec))
+
+	forwarded := p.Calls[0].Arguments.Get(0).(kafka.FTMessage)
+	assert.Equal(t, `{"uuid":"`+aUUID+`"}`, forwarded.Body)
+	assert.Equal(t, "27f79e6d884acdd642d1758c4fd30d43074f8384d552d1ebb1959345", forwarded.Headers["Native-Hash"])
+	assert.Equal(t, "copied", forwarded.Headers["X-Custom-Header"], "The headers the config rules match on should be copied")
+	assert.NotContains(t, forwarded.Headers, "Authorization", "Only the message headers should be copied")
+	assert.Equal(t, "universal-content", forwarded.Headers["X-Ingest-Collection"])
+	assert.Equal(t, aUUID, forwarded.Headers["X-Ingest-Content-UUID"])
+	assert.Equal(t, "200", forwarded.Headers["X-Ingest-Native-Writer-Status"])
+	w.AssertExpectations(t)
+}
+
+func TestIngestReportsEveryCollection(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	w := new(mocks.WriterMock)
+	w.On("GetRule", cctOriginSystemID, aContentType, []interface{}(nil)).Return(config.OriginSystemConfig{Collection: "universal-content", AdditionalCollections: []string{"audit"}}, nil)
+	w.On("WriteToCollection", mock.AnythingOfType("native.NativeMessage"), "universal-content").Return(aUUID, "", http.StatusOK, nil)
+	w.On("WriteToCollection", mock.AnythingOfType("native.NativeMessage"), "audit").Return(aUUID, "", http.StatusServiceUnavailable, errors.New("Native writer returned non-200 code"))
+
+	h := NewIngestHandler(queue.NewMessageHandler(w, "Content", log), ingestConfig(t), log)
+	rec := httptest.NewRecorder()
+	h.Ingest(rec, newIngestRequest(`{"uuid":"`+aUUID+`"}`, ingestRequestHeaders))
+
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+	resp := decodeIngestResponse(t, rec)
+	assert.Equal(t, "audit: Native writer returned non-200 code", resp["error"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"collection": "universal-content", "contentUUID": aUUID},
+		map[string]interface{}{"collection": "audit", "contentUUID": aUUID, "error": "Native writer returned non-200 code"},
+	}, resp["writes"])
+}
+
+func TestIngestGeneratesTransactionID(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	w := new(mocks.WriterMock)
+	w.On("GetRule", cctOriginSystemID, aContentType, []interface{}(nil)).Return(config.OriginSystemConfig{Collection: "universal-content"}, nil)
+	w.On("WriteToCollection", mock.MatchedBy(func(msg native.NativeMessage) bool {
+		return strings.HasPrefix(msg.TransactionID(), "tid_ingest_")
+	}), "universal-content").Return(aUUID, "", http.StatusOK, nil)
+
+	h := NewIngestHandler(queue.NewMessageHandler(w, "Content", log), ingestConfig(t), log)
+
+	headers := map[string]string{
+		"Origin-System-Id":  cctOriginSystemID,
+		"Content-Type":      aContentType,
+		"Message-Timestamp": "2017-02-16T12:56:16Z",
+	}
+	rec := httptest.NewRecorder()
+	h.Ingest(rec, newIngestRequest(`{}`, headers))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, false, decodeIngestResponse(t, rec)["forwarded"])
+	w.AssertExpectations(t)
+}
+
+func TestIngestRejectsTooLargeBody(t *testing.T) {
+	log := logger.NewUnstructuredLogger()
+	w := new(mocks.WriterMock)
+	h := NewIngestHandler(queue.NewMessageHandler(w, "Content", log), ingestConfig(t), log)
+
+	rec := httptest.NewRecorder()
+	h.Ingest(rec, newIngestRequest(`{"uuid":"`+aUUID+`","body":"`+strings.Repeat("a", maxIngestBodySize)+`"}`, ingestRequestHeaders))
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	assert.Equal(t, map[string]interface{}{
+		"forwarded": false,
+		"error":     "request body exceeds 10485760 bytes",
+	}, decodeIngestResponse(t, rec))
+	w.AssertNotCalled(t, "GetRule", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestIngestFailures(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		collection error
+		writerErr  error
+		status     int
+		stage      string
+	}{
+		{"invalid body", "I am not JSON", nil, nil, http.StatusBadRequest, "unmarshal"},
+		{"not whitelisted", "{}", errors.New("origin system not found"), nil, http.StatusUnprocessableEntity, ""},
+		{"missing uuid", "{}", nil, &native.UUIDExtractionError{Err: errors.New("UUID not found")}, http.StatusBadRequest, "uuid-extraction"},
+		{"writer failure", "{}", nil, errors.New("Native writer returned non-200 code"), http.StatusBadGateway, "write"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := logger.NewUnstructuredLogger()
+			w := new(mocks.WriterMock)
+			collection := "universal-content"
+			if tt.collection != nil {
+				collection = ""
+			}
+			w.On("GetRule", cctOriginSystemID, aContentType, []interface{}(nil)).Return(config.OriginSystemConfig{Collection: collection}, tt.collection)
+			w.On("WriteToCollection", mock.AnythingOfType("native.NativeMessage"), "universal-content").Return("", "", 0, tt.writerErr)
+
+			h := NewIngestHandler(queue.NewMessageHandler(w, "Content", log), ingestConfig(t), log)
+			rec := httptest.NewRecorder()
+			h.Ingest(rec, newIngestRequest(tt.body, ingestRequestHeaders))
+
+			assert.Equal(t, tt.status, rec.Code)
+			resp := decodeIngestResponse(t, rec)
+			assert.NotEmpty(t, resp["error"])
+			if tt.stage != "" {
+				assert.Equal(t, tt.stage, resp["failedStage"])
+			}
+			assert.Equal(t, false, resp["forwarded"])
+		})
+	}
+}